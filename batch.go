@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources préférées pour les téléchargements groupés, par ordre de priorité
+// (noms tels qu'affichés dans la fiche, insensibles à la casse). Les sources
+// absentes de la liste passent après, dans l'ordre de la fiche.
+var PreferredSources []string
+
+// parseSourcePreferences découpe une liste "source1,source2".
+func parseSourcePreferences(raw string) []string {
+	var prefs []string
+	for _, p := range strings.Split(raw, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefs = append(prefs, strings.ToLower(p))
+		}
+	}
+	return prefs
+}
+
+// rankSources ne garde que les sources que la file sait télécharger (M3U8 et
+// MP4 directs) et les trie selon les préférences.
+func rankSources(sources []MediaSource, prefs []string) []MediaSource {
+	rank := func(s MediaSource) int {
+		name := strings.ToLower(s.Name)
+		for i, p := range prefs {
+			if strings.Contains(name, p) {
+				return i
+			}
+		}
+		return len(prefs)
+	}
+
+	var ranked []MediaSource
+	for _, s := range sources {
+		if s.Format == "m3u8" || s.Format == "mp4" {
+			ranked = append(ranked, s)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return rank(ranked[i]) < rank(ranked[j]) })
+	return ranked
+}
+
+// isSourceAlive vérifie qu'une source répond, comme /api/check-url.
+func isSourceAlive(ctx context.Context, target string) bool {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", target, nil)
+	if err != nil {
+		return false
+	}
+	client := &http.Client{Timeout: 3 * time.Second, Transport: outboundTransport, CheckRedirect: checkOutboundRedirect}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+// pickLiveSource renvoie la source téléchargeable préférée qui répond.
+func pickLiveSource(ctx context.Context, sources []MediaSource, prefs []string) (MediaSource, error) {
+	candidates := rankSources(sources, prefs)
+	if len(candidates) == 0 {
+		return MediaSource{}, fmt.Errorf("aucune source M3U8 ou MP4")
+	}
+	for _, c := range candidates {
+		if isSourceAlive(ctx, c.URL) {
+			return c, nil
+		}
+	}
+	return MediaSource{}, fmt.Errorf("aucune source en ligne")
+}
+
+// Résultat d'un épisode dans un téléchargement groupé
+type BatchItem struct {
+	Season  int    `json:"season"`
+	Episode int    `json:"episode"`
+	Name    string `json:"name,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Job     *Job   `json:"job,omitempty"`
+	Reason  string `json:"reason,omitempty"` // pourquoi l'épisode n'a pas été mis en file
+
+	sourceName string
+}
+
+type BatchResult struct {
+	Title   string      `json:"title"`
+	Queued  []BatchItem `json:"queued"`
+	Skipped []BatchItem `json:"skipped"`
+}
+
+// ErrNoEpisodes signale une saison (ou une série) sans aucun épisode dans la fiche.
+var ErrNoEpisodes = errors.New("aucun épisode trouvé")
+
+// queueSeriesBatch met en file un job par épisode d'une saison (season > 0)
+// ou de toute la série (season == 0), avec la meilleure source vivante.
+func queueSeriesBatch(ctx context.Context, mediaID string, season int, prefs []string, opts M3U8Options) (BatchResult, error) {
+	result := BatchResult{Queued: []BatchItem{}, Skipped: []BatchItem{}}
+
+	detail, err := buildMediaDetail(ctx, mediaID)
+	if err != nil {
+		return result, err
+	}
+	result.Title = detail.Title
+
+	var items []BatchItem
+	var episodes []MediaEpisode
+	for _, s := range detail.Seasons {
+		if season != 0 && s.Number != season {
+			continue
+		}
+		for _, ep := range s.Episodes {
+			items = append(items, BatchItem{Season: s.Number, Episode: ep.Number, Name: ep.Name})
+			episodes = append(episodes, ep)
+		}
+	}
+	if len(episodes) == 0 {
+		return result, ErrNoEpisodes
+	}
+
+	// Vérification des sources en parallèle (au plus 8 épisodes à la fois)
+	sem := make(chan struct{}, 8)
+	var wg sync.WaitGroup
+	for i, ep := range episodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			source, err := pickLiveSource(ctx, ep.Sources, prefs)
+			if err != nil {
+				items[i].Reason = err.Error()
+				return
+			}
+			items[i].Source = source.URL
+			items[i].Kind = downloadKind(source.Format)
+			items[i].sourceName = source.Name
+		}()
+	}
+	wg.Wait()
+
+	// Mise en file dans l'ordre des épisodes
+	for _, item := range items {
+		if item.Source == "" {
+			result.Skipped = append(result.Skipped, item)
+			continue
+		}
+		title := fmt.Sprintf("%s S%02dE%02d", result.Title, item.Season, item.Episode)
+		epOpts := opts
+		epOpts.Media = MediaInfo{
+			Title:       detail.Title,
+			Year:        detail.Year,
+			Season:      item.Season,
+			Episode:     item.Episode,
+			EpisodeName: item.Name,
+			Source:      item.sourceName,
+		}
+		job := jobs.Create(title, item.Source)
+		downloadQueue.Add(queueEntry{JobID: job.ID, Title: title, URL: item.Source, Kind: item.Kind, Options: epOpts})
+		item.Job = &job
+		result.Queued = append(result.Queued, item)
+	}
+	return result, nil
+}
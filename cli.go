@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Codes de sortie du mode ligne de commande, stables pour les scripts
+const (
+	exitOK          = 0
+	exitFailure     = 1 // téléchargement en échec
+	exitUsage       = 2 // commande, argument ou configuration invalide
+	exitNotFound    = 3 // média, saison, épisode ou source introuvable
+	exitAPI         = 4 // API injoignable ou réponse inattendue
+	exitInterrupted = 130
+)
+
+type cliCommand struct {
+	name, args, summary string
+	run                 func(args []string) int
+}
+
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{"serve", "[options]", "démarre l'interface web (commande par défaut)", serve},
+		{"search", "<recherche>", "recherche un film ou une série", cmdSearch},
+		{"info", "<id>", "affiche la fiche d'un média (saisons, sources)", cmdInfo},
+		{"episodes", "<id> <saison>", "liste les épisodes d'une saison", cmdEpisodes},
+		{"download", "<id> [--season N] [--episode M] [--source-name X]", "télécharge un film, un épisode, une saison ou une série", cmdDownload},
+		{"update", "[--check]", "installe la nouvelle version (--check : vérifie seulement)", cmdUpdate},
+	}
+}
+
+func cliUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage : xaladownloader <commande> [arguments] [--json]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range cliCommands() {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Codes de sortie : 0 succès, 1 échec du téléchargement, 2 usage invalide,")
+	fmt.Fprintln(w, "3 introuvable, 4 API injoignable, 130 interrompu.")
+}
+
+// runCLI exécute une sous-commande et renvoie son code de sortie.
+func runCLI(name string, args []string) int {
+	for _, c := range cliCommands() {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	if name == "help" {
+		cliUsage(os.Stdout)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Commande inconnue : %s\n\n", name)
+	cliUsage(os.Stderr)
+	return exitUsage
+}
+
+// Options communes aux sous-commandes
+type cliContext struct {
+	ctx    context.Context
+	json   bool
+	config string
+}
+
+func newCLIFlags(name string) (*flag.FlagSet, *cliContext) {
+	c := &cliContext{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.BoolVar(&c.json, "json", false, "sortie JSON sur stdout")
+	fs.StringVar(&c.config, "config", "", "fichier de configuration TOML")
+	return fs, c
+}
+
+// parseCLI lit options et arguments dans n'importe quel ordre
+// ("download 42 --season 1" comme "download --season 1 42").
+func parseCLI(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// loadConfig charge et applique la configuration.
+func (c *cliContext) loadConfig() int {
+	var args []string
+	if c.config != "" {
+		args = []string{"-config", c.config}
+	}
+	cfg, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration invalide :\n%v\n", err)
+		return exitUsage
+	}
+	cfg.apply()
+	ConsoleProgress = false
+	return exitOK
+}
+
+// setup charge la configuration et l'adresse de l'API. Renvoie un code de
+// sortie non nul en cas d'échec.
+func (c *cliContext) setup() int {
+	if code := c.loadConfig(); code != exitOK {
+		return code
+	}
+
+	if replaying() {
+		setBaseURL(replayBaseURL)
+		return exitOK
+	}
+	if err := discovery.Run(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, "Adresse de l'API introuvable (voir discovery.override et discovery.mirrors) :", err)
+		return exitAPI
+	}
+	return exitOK
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// apiExitCode distingue un média inexistant (404) d'une API en erreur.
+func apiExitCode(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return exitNotFound
+	}
+	return exitAPI
+}
+
+// cliParse prépare une sous-commande : options, nombre d'arguments, config et
+// API. Si ok est faux, la commande s'arrête avec le code renvoyé.
+func cliParse(fs *flag.FlagSet, c *cliContext, args []string, want int, usage string) (positional []string, code int, ok bool) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage : xaladownloader %s %s\n", fs.Name(), usage)
+		fs.PrintDefaults()
+	}
+	positional, err := parseCLI(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, exitOK, false
+	}
+	if err != nil {
+		return nil, exitUsage, false
+	}
+	if len(positional) != want {
+		fs.Usage()
+		return nil, exitUsage, false
+	}
+	if code := c.setup(); code != exitOK {
+		return nil, code, false
+	}
+	c.ctx = context.Background()
+	return positional, exitOK, true
+}
+
+func cmdSearch(args []string) int {
+	fs, c := newCLIFlags("search")
+	positional, code, ok := cliParse(fs, c, args, 1, "<recherche>")
+	if !ok {
+		return code
+	}
+
+	results, err := api.Search(c.ctx, positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Erreur de recherche :", err)
+		return apiExitCode(err)
+	}
+	if c.json {
+		printJSON(results)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTYPE\tTITRE\tDURÉE")
+		for _, m := range results {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", m.ID, m.Kind, m.Title, m.Runtime)
+		}
+		tw.Flush()
+	}
+	if len(results) == 0 {
+		return exitNotFound
+	}
+	return exitOK
+}
+
+func cmdInfo(args []string) int {
+	fs, c := newCLIFlags("info")
+	positional, code, ok := cliParse(fs, c, args, 1, "<id>")
+	if !ok {
+		return code
+	}
+
+	detail, err := buildMediaDetail(c.ctx, positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fiche introuvable :", err)
+		return apiExitCode(err)
+	}
+	if c.json {
+		printJSON(detail)
+		return exitOK
+	}
+
+	title := detail.Title
+	if detail.Year != "" {
+		title += " (" + detail.Year + ")"
+	}
+	fmt.Printf("%s [%s] #%d\n", title, detail.Type, detail.ID)
+	for _, s := range detail.Seasons {
+		fmt.Printf("  Saison %02d : %d épisode(s)\n", s.Number, len(s.Episodes))
+	}
+	if len(detail.Sources) > 0 {
+		fmt.Println("Sources :")
+		printSources(detail.Sources, "  ")
+	}
+	return exitOK
+}
+
+func printSources(sources []MediaSource, indent string) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, s := range sources {
+		fmt.Fprintf(tw, "%s[%s]\t%s\t%s\n", indent, s.Format, s.Name, s.URL)
+	}
+	tw.Flush()
+}
+
+// findSeason renvoie une saison de la fiche.
+func findSeason(detail MediaDetail, number int) (MediaSeason, bool) {
+	for _, s := range detail.Seasons {
+		if s.Number == number {
+			return s, true
+		}
+	}
+	return MediaSeason{}, false
+}
+
+func cmdEpisodes(args []string) int {
+	fs, c := newCLIFlags("episodes")
+	positional, code, ok := cliParse(fs, c, args, 2, "<id> <saison>")
+	if !ok {
+		return code
+	}
+	number, err := strconv.Atoi(positional[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Saison invalide : %s\n", positional[1])
+		return exitUsage
+	}
+
+	detail, err := buildMediaDetail(c.ctx, positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fiche introuvable :", err)
+		return apiExitCode(err)
+	}
+	season, ok := findSeason(detail, number)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Saison %d introuvable pour %s\n", number, detail.Title)
+		return exitNotFound
+	}
+	if c.json {
+		printJSON(season.Episodes)
+		return exitOK
+	}
+	for _, ep := range season.Episodes {
+		fmt.Printf("S%02dE%02d  %s\n", number, ep.Number, ep.Name)
+		printSources(ep.Sources, "    ")
+	}
+	return exitOK
+}
+
+// Élément à télécharger : un film ou un épisode
+type cliTarget struct {
+	label   string
+	info    MediaInfo
+	sources []MediaSource
+}
+
+// Résultat d'un téléchargement pour la sortie JSON
+type cliDownloadResult struct {
+	Label  string `json:"label"`
+	Source string `json:"source,omitempty"`
+	Job    *Job   `json:"job,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func cmdDownload(args []string) int {
+	fs, c := newCLIFlags("download")
+	season := fs.Int("season", 0, "saison à télécharger (série : toutes si absent)")
+	episode := fs.Int("episode", 0, "épisode à télécharger (toute la saison si absent)")
+	sourceName := fs.String("source-name", "", "n'utiliser que les sources dont le nom contient ce texte")
+	format := fs.String("format", ContainerMP4, "conteneur de sortie : mp4 ou ts")
+	variant := fs.String("variant", "", "variante : best, worst, height ou bandwidth")
+	height := fs.Int("height", 0, "hauteur visée avec --variant height")
+	maxBandwidth := fs.Int("max-bandwidth", 0, "débit maximal avec --variant bandwidth")
+	positional, code, ok := cliParse(fs, c, args, 1, "<id> [--season N] [--episode M] [--source-name X]")
+	if !ok {
+		return code
+	}
+	if *episode > 0 && *season == 0 {
+		fmt.Fprintln(os.Stderr, "--episode nécessite --season")
+		return exitUsage
+	}
+
+	q := url.Values{"format": {*format}, "variant": {*variant}}
+	if *height > 0 {
+		q.Set("height", strconv.Itoa(*height))
+	}
+	if *maxBandwidth > 0 {
+		q.Set("maxBandwidth", strconv.Itoa(*maxBandwidth))
+	}
+	opts, err := parseM3U8Options(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	detail, err := buildMediaDetail(c.ctx, positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Fiche introuvable :", err)
+		return apiExitCode(err)
+	}
+	targets, err := downloadTargets(detail, *season, *episode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitNotFound
+	}
+
+	prefs := PreferredSources
+	if *sourceName != "" {
+		prefs = []string{strings.ToLower(*sourceName)}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []cliDownloadResult
+	exit := exitOK
+	for _, t := range targets {
+		result := cliDownloadResult{Label: t.label}
+		sources := t.sources
+		if *sourceName != "" {
+			sources = filterSources(sources, *sourceName)
+		}
+		source, err := pickLiveSource(ctx, sources, prefs)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			fmt.Fprintf(os.Stderr, "%s : %v\n", t.label, err)
+			exit = max(exit, exitNotFound)
+			continue
+		}
+		result.Source = source.URL
+
+		jobOpts := opts
+		jobOpts.Media = t.info
+		jobOpts.Media.Source = source.Name
+		job, interrupted := runCLIJob(ctx, t.label, source.URL, downloadKind(source.Format), jobOpts, !c.json)
+		result.Job = &job
+		if job.State != JobCompleted {
+			result.Error = job.LastError
+			exit = exitFailure
+		}
+		results = append(results, result)
+		if interrupted {
+			exit = exitInterrupted
+			break
+		}
+	}
+
+	if c.json {
+		printJSON(results)
+	}
+	return exit
+}
+
+// downloadTargets liste ce qu'il faut télécharger : le film, un épisode,
+// une saison ou toute la série.
+func downloadTargets(detail MediaDetail, season, episode int) ([]cliTarget, error) {
+	base := MediaInfo{Title: detail.Title, Year: detail.Year}
+	if len(detail.Seasons) == 0 {
+		if season > 0 {
+			return nil, fmt.Errorf("%s n'a pas de saisons", detail.Title)
+		}
+		return []cliTarget{{label: detail.Title, info: base, sources: detail.Sources}}, nil
+	}
+
+	var targets []cliTarget
+	for _, s := range detail.Seasons {
+		if season > 0 && s.Number != season {
+			continue
+		}
+		for _, ep := range s.Episodes {
+			if episode > 0 && ep.Number != episode {
+				continue
+			}
+			info := base
+			info.Season, info.Episode, info.EpisodeName = s.Number, ep.Number, ep.Name
+			targets = append(targets, cliTarget{
+				label:   fmt.Sprintf("%s S%02dE%02d", detail.Title, s.Number, ep.Number),
+				info:    info,
+				sources: ep.Sources,
+			})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("aucun épisode correspondant pour %s", detail.Title)
+	}
+	return targets, nil
+}
+
+func filterSources(sources []MediaSource, name string) []MediaSource {
+	var kept []MediaSource
+	for _, s := range sources {
+		if strings.Contains(strings.ToLower(s.Name), strings.ToLower(name)) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// runCLIJob exécute un téléchargement au premier plan avec une barre de
+// progression. Une interruption (Ctrl+C) annule le job en gardant le fichier
+// partiel : relancer la même commande reprend là où elle s'était arrêtée.
+func runCLIJob(ctx context.Context, label, streamURL, kind string, opts M3U8Options, showProgress bool) (Job, bool) {
+	sub := events.Subscribe()
+	defer events.Unsubscribe(sub)
+
+	job := jobs.Create(label, streamURL)
+	done := make(chan struct{})
+	go func() {
+		runDownloadJob(queueEntry{JobID: job.ID, Title: label, URL: streamURL, Kind: kind, Options: opts})
+		close(done)
+	}()
+
+	interrupted := false
+	cancelled := ctx.Done()
+	for {
+		select {
+		case e, ok := <-sub:
+			if !ok {
+				sub = nil // déconnecté car trop lent : l'état final est lu à la fin
+				continue
+			}
+			if showProgress && e.Job.ID == job.ID {
+				renderProgress(os.Stderr, e)
+			}
+		case <-cancelled:
+			interrupted = true
+			jobs.Cancel(job.ID, true)
+			cancelled = nil // un canal nil n'est plus jamais prêt : pas de boucle active jusqu'à done
+		case <-done:
+			final, _ := jobs.Get(job.ID)
+			if showProgress {
+				fmt.Fprintln(os.Stderr)
+				switch final.State {
+				case JobCompleted:
+					fmt.Fprintf(os.Stderr, "✔ %s -> %s\n", label, final.OutputPath)
+				case JobCancelled:
+					fmt.Fprintf(os.Stderr, "✖ %s interrompu (relancez la commande pour reprendre)\n", label)
+				default:
+					fmt.Fprintf(os.Stderr, "✖ %s : %s\n", label, final.LastError)
+				}
+			}
+			return final, interrupted
+		}
+	}
+}
+
+// renderProgress redessine la ligne de progression d'un job.
+func renderProgress(w io.Writer, e Event) {
+	j := e.Job
+	const width = 30
+	line := j.Status
+	if e.Type == EventRetrying {
+		line = fmt.Sprintf("Nouvel essai %d/5 : %s", e.Attempt, e.Segment)
+	} else if j.SegmentsTotal > 0 && j.State == JobRunning {
+		filled := width * j.SegmentsDone / j.SegmentsTotal
+		line = fmt.Sprintf("[%s%s] %3d%% %d/%d  %s/s  ETA %s",
+			strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+			100*j.SegmentsDone/j.SegmentsTotal, j.SegmentsDone, j.SegmentsTotal,
+			formatBytes(j.Speed), (time.Duration(j.ETA) * time.Second).String())
+	}
+	fmt.Fprintf(w, "\r\033[K%s", line)
+}
+
+func formatBytes(n float64) string {
+	units := []string{"o", "Ko", "Mo", "Go"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+func cmdUpdate(args []string) int {
+	fs, c := newCLIFlags("update")
+	check := fs.Bool("check", false, "vérifie la disponibilité d'une mise à jour sans l'installer")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage : xaladownloader update [--check]")
+		fs.PrintDefaults()
+	}
+	positional, err := parseCLI(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if code := c.loadConfig(); code != exitOK {
+		return code
+	}
+
+	info, err := CheckForUpdates(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Vérification des mises à jour impossible :", err)
+		return exitAPI
+	}
+	if info != nil && !*check {
+		if !c.json {
+			announceUpdate(info)
+		}
+		if _, err := ApplyUpdate(); err != nil {
+			fmt.Fprintln(os.Stderr, "Erreur de mise à jour :", err)
+			if errors.Is(err, ErrSelfUpdateDisabled) {
+				return exitUsage
+			}
+			return exitFailure
+		}
+	}
+
+	switch {
+	case c.json:
+		printJSON(UpdateState())
+	case info == nil:
+		fmt.Printf("Déjà à jour (%s, canal %s)\n", CurrentVersion, UpdateChannel)
+	case *check:
+		announceUpdate(info)
+	default:
+		fmt.Printf("Mise à jour %s installée.\n", info.Version)
+	}
+	return exitOK
+}
@@ -3,31 +3,272 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
-var BaseURL string
+// Adresse actuelle de l'API, remplacée d'un bloc par la découverte
+var currentBaseURL atomic.Pointer[string]
+
+// BaseURL renvoie l'adresse actuelle de l'API ("" tant qu'aucune n'a été validée).
+func BaseURL() string {
+	if u := currentBaseURL.Load(); u != nil {
+		return *u
+	}
+	return ""
+}
+
+func setBaseURL(u string) { currentBaseURL.Store(&u) }
+
+// Page donnant l'adresse actuelle de l'API et fréquence de sa relecture
+// (réglables : discovery.url et discovery.refresh_interval)
+var (
+	DiscoveryURL             = "https://purstream.wiki"
+	DiscoveryRefreshInterval = 6 * time.Hour
+)
+
+// Adresse imposée et miroirs de secours (réglables : discovery.override et discovery.mirrors)
+var (
+	APIOverride string
+	APIMirrors  = []string{"https://api.purstream.art"}
+)
+
+// Origines possibles d'une adresse, dans l'ordre où elles sont essayées
+const (
+	sourceOverride = "override"
+	sourceSaved    = "saved"
+	sourceWiki     = "wiki"
+	sourceMirror   = "mirror"
+)
+
+// Essai d'une adresse candidate lors de la dernière découverte
+type DiscoveryAttempt struct {
+	Source    string `json:"source"`
+	URL       string `json:"url,omitempty"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// État de la découverte, exposé par /api/discovery
+type DiscoveryStatus struct {
+	BaseURL   string             `json:"baseUrl"`
+	Source    string             `json:"source,omitempty"`
+	Since     time.Time          `json:"since,omitzero"`     // adoption de l'adresse actuelle
+	CheckedAt time.Time          `json:"checkedAt,omitzero"` // dernière découverte
+	LastError string             `json:"lastError,omitempty"`
+	Attempts  []DiscoveryAttempt `json:"attempts"`
+}
+
+// Discovery choisit l'adresse de l'API en essayant, dans l'ordre : l'adresse
+// imposée, la dernière adresse valide (sauvegardée sur disque), celle donnée
+// par la page de découverte, puis les miroirs. Chaque candidate est vérifiée
+// par un vrai appel à l'API avant d'être adoptée.
+type Discovery struct {
+	run  sync.Mutex // une découverte à la fois
+	mu   sync.RWMutex
+	path string
+	st   DiscoveryStatus
+}
+
+var discovery = NewDiscovery(defaultDiscoveryPath())
+
+func defaultDiscoveryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir, _ = os.UserHomeDir()
+	}
+	return filepath.Join(dir, "Xaladownloader", "api.json")
+}
+
+func NewDiscovery(path string) *Discovery {
+	return &Discovery{path: path, st: DiscoveryStatus{Attempts: []DiscoveryAttempt{}}}
+}
+
+// Status renvoie une copie de l'état courant.
+func (d *Discovery) Status() DiscoveryStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	st := d.st
+	st.BaseURL = BaseURL()
+	st.Attempts = append([]DiscoveryAttempt{}, d.st.Attempts...)
+	return st
+}
+
+// Fichier de la dernière adresse valide
+type savedBaseURL struct {
+	URL       string    `json:"url"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+func (d *Discovery) loadSaved() string {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return ""
+	}
+	var saved savedBaseURL
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Adresse de l'API sauvegardée illisible (%s) : %v", d.path, err)
+		return ""
+	}
+	return saved.URL
+}
+
+func (d *Discovery) save(u string) error {
+	data, err := json.MarshalIndent(savedBaseURL{URL: u, CheckedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// find essaie les candidates dans l'ordre et renvoie la première qui répond.
+// La page de découverte n'est lue que si les précédentes ont échoué.
+func (d *Discovery) find(ctx context.Context) (found, source string, attempts []DiscoveryAttempt) {
+	attempts = []DiscoveryAttempt{}
+	tried := map[string]bool{}
+	try := func(source, raw string) (string, bool) {
+		u := strings.TrimRight(strings.TrimSpace(raw), "/")
+		if u == "" || tried[u] {
+			return "", false
+		}
+		tried[u] = true
+		start := time.Now()
+		err := probeAPI(ctx, u)
+		a := DiscoveryAttempt{Source: source, URL: u, OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			a.Error = err.Error()
+		}
+		attempts = append(attempts, a)
+		return u, err == nil
+	}
+
+	if u, ok := try(sourceOverride, APIOverride); ok {
+		return u, sourceOverride, attempts
+	}
+	if u, ok := try(sourceSaved, d.loadSaved()); ok {
+		return u, sourceSaved, attempts
+	}
+	if scraped, err := FetchBaseURL(ctx); err != nil {
+		attempts = append(attempts, DiscoveryAttempt{Source: sourceWiki, Error: err.Error()})
+	} else if u, ok := try(sourceWiki, scraped); ok {
+		return u, sourceWiki, attempts
+	}
+	for _, m := range APIMirrors {
+		if u, ok := try(sourceMirror, m); ok {
+			return u, sourceMirror, attempts
+		}
+	}
+	return "", "", attempts
+}
+
+// Run parcourt la chaîne de découverte et adopte la première adresse qui
+// répond. En cas d'échec complet, l'adresse actuelle est conservée.
+func (d *Discovery) Run(ctx context.Context) error {
+	d.run.Lock()
+	defer d.run.Unlock()
+
+	found, source, attempts := d.find(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.st.CheckedAt = time.Now()
+	d.st.Attempts = attempts
+	if found == "" {
+		d.st.LastError = "aucune adresse de l'API ne répond"
+		return errors.New(d.st.LastError)
+	}
+	d.st.LastError = ""
+	if found != BaseURL() {
+		setBaseURL(found)
+		d.st.Since = d.st.CheckedAt
+		log.Printf("Adresse de l'API : %s (%s)", found, source)
+	}
+	d.st.Source = source
+	if err := d.save(found); err != nil {
+		log.Printf("Sauvegarde de l'adresse de l'API impossible : %v", err)
+	}
+	return nil
+}
+
+// probeAPI vérifie qu'une adresse répond comme l'API Purstream : statut 200
+// et réponse JSON attendue sur un endpoint léger.
+func probeAPI(ctx context.Context, base string) error {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL invalide")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/api/v1/last-released-movies/1", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("statut HTTP %d", resp.StatusCode)
+	}
+	var body struct {
+		Data *json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Data == nil {
+		return fmt.Errorf("réponse inattendue (pas l'API Purstream)")
+	}
+	return nil
+}
 
 func InitApp() {
-	startBaseURLRefresher(6 * time.Hour)
-	log.Println("URL détectée :", BaseURL)
+	if replaying() {
+		setBaseURL(replayBaseURL)
+		log.Println("Mode replay : réponses de l'API relues depuis", FixturesDir)
+		return
+	}
+	startBaseURLRefresher(DiscoveryRefreshInterval)
+	log.Println("URL détectée :", BaseURL())
 }
 
-func FetchBaseURL() (string, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// FetchBaseURL lit l'adresse de l'API sur la page de découverte.
+func FetchBaseURL(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", DiscoveryURL, nil)
+	if err != nil {
+		return "", err
+	}
 
-	resp, err := client.Get("https://purstream.wiki")
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("page de découverte : statut HTTP %d", resp.StatusCode)
+	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
@@ -37,7 +278,7 @@ func FetchBaseURL() (string, error) {
 	// 1. Extraction de l'URL brute depuis la classe url-display
 	rawURL, exists := doc.Find("a.url-display").First().Attr("href")
 	if !exists || rawURL == "" {
-		return "https://api.purstream.art", fmt.Errorf("element .url-display introuvable")
+		return "", fmt.Errorf("element .url-display introuvable")
 	}
 
 	// 2. Parsing de l'URL pour manipuler le Host
@@ -58,60 +299,25 @@ func FetchBaseURL() (string, error) {
 	return strings.TrimRight(u.String(), "/"), nil
 }
 
+// startBaseURLRefresher lance une première découverte puis la relance à
+// intervalle régulier (toutes les minutes tant qu'aucune adresse ne répond).
 func startBaseURLRefresher(interval time.Duration) {
-	// Premier appel au démarrage pour initialiser la variable
 	updateURL()
 
-	// Ticker pour les prochaines mises à jour
-	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
+		for {
+			wait := interval
+			if BaseURL() == "" {
+				wait = time.Minute
+			}
+			time.Sleep(wait)
 			updateURL()
 		}
 	}()
 }
 
 func updateURL() {
-	newURL, err := FetchBaseURL()
-	if err != nil {
+	if err := discovery.Run(context.Background()); err != nil {
 		log.Printf("Erreur lors du rafraîchissement auto de l'URL : %v", err)
-		return
-	}
-
-	// On met à jour la variable globale (BaseURL)
-	// Idéalement, utilise un Mutex ici si tu as beaucoup de trafic
-	BaseURL = newURL
-	log.Printf("BaseURL mise à jour automatiquement : %s", BaseURL)
-}
-
-func fetchMedia(ctx context.Context, query string) ([]Media, error) {
-	remote := fmt.Sprintf("%s/api/v1/search-bar/search/%s", BaseURL, url.QueryEscape(query))
-	req, _ := http.NewRequestWithContext(ctx, "GET", remote, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	client := &http.Client{Timeout: 12 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var apiData PurestreamResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiData); err != nil {
-		log.Printf("Erreur décodage Search: %v", err)
-		return nil, err
-	}
-
-	results := []Media{}
-	for _, m := range apiData.Data.Items.Movies.Items {
-		results = append(results, Media{
-			Title:    m.Title,
-			ID:       m.ID,
-			ThumbURL: m.LargePosterPath, // On utilise le nouveau champ direct
-			Kind:     m.Type,
-			Runtime:  fmt.Sprintf("%d min", m.Runtime), // Conversion int -> string
-			Updated:  m.UpdatedAt,
-		})
 	}
-	return results, nil
 }
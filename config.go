@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Durée lisible ("6h", "90m") dans le fichier de config, les variables
+// d'environnement et le JSON de /api/config.
+type Duration struct{ time.Duration }
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("durée invalide %q (ex : 6h, 30m)", string(b))
+	}
+	d.Duration = v
+	return nil
+}
+
+type ServerConfig struct {
+	Host        string `toml:"host" json:"host"`
+	Port        int    `toml:"port" json:"port"`
+	OpenBrowser bool   `toml:"open_browser" json:"openBrowser"`
+	Docker      bool   `toml:"docker" json:"docker"` // téléchargements serveur désactivés
+}
+
+type UpdatesConfig struct {
+	URL     string `toml:"url" json:"url"`
+	Channel string `toml:"channel" json:"channel"` // stable ou beta
+}
+
+type DiscoveryConfig struct {
+	URL             string   `toml:"url" json:"url"`
+	RefreshInterval Duration `toml:"refresh_interval" json:"refreshInterval"`
+	Override        string   `toml:"override" json:"override"` // adresse de l'API imposée
+	Mirrors         []string `toml:"mirrors" json:"mirrors"`   // adresses de secours
+}
+
+type DownloadsConfig struct {
+	Workers          int      `toml:"workers" json:"workers"`
+	ReorderWindow    int      `toml:"reorder_window" json:"reorderWindow"`
+	MaxConcurrent    int      `toml:"max_concurrent" json:"maxConcurrent"`
+	Connections      int      `toml:"connections" json:"connections"` // connexions par téléchargement MP4
+	PreferredSources []string `toml:"preferred_sources" json:"preferredSources"`
+}
+
+type LibraryConfig struct {
+	Root            string `toml:"root" json:"root"`
+	MovieTemplate   string `toml:"movie_template" json:"movieTemplate"`
+	EpisodeTemplate string `toml:"episode_template" json:"episodeTemplate"`
+}
+
+type APIConfig struct {
+	Mode     string `toml:"mode" json:"mode"`         // live, record ou replay
+	Fixtures string `toml:"fixtures" json:"fixtures"` // dossier des réponses enregistrées
+}
+
+type OutboundConfig struct {
+	Schemes        []string `toml:"schemes" json:"schemes"`                 // schémas autorisés (http, https)
+	AllowPrivate   bool     `toml:"allow_private" json:"allowPrivate"`      // loopback, réseaux privés et link-local
+	SheetHostsOnly bool     `toml:"sheet_hosts_only" json:"sheetHostsOnly"` // seulement les hôtes vus dans une fiche servie
+}
+
+// Config regroupe tous les réglages du serveur. Priorité croissante :
+// valeurs par défaut < fichier TOML < variables d'environnement < options de ligne de commande.
+type Config struct {
+	Server    ServerConfig    `toml:"server" json:"server"`
+	Updates   UpdatesConfig   `toml:"updates" json:"updates"`
+	Discovery DiscoveryConfig `toml:"discovery" json:"discovery"`
+	Downloads DownloadsConfig `toml:"downloads" json:"downloads"`
+	Library   LibraryConfig   `toml:"library" json:"library"`
+	API       APIConfig       `toml:"api" json:"api"`
+	Outbound  OutboundConfig  `toml:"outbound" json:"outbound"`
+
+	File string `toml:"-" json:"file,omitempty"` // fichier chargé, vide si aucun
+}
+
+// Configuration effective, chargée au démarrage
+var appConfig = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server:  ServerConfig{Host: "", Port: 8080, OpenBrowser: true},
+		Updates: UpdatesConfig{URL: UpdateConfigURL, Channel: UpdateChannel},
+		Discovery: DiscoveryConfig{
+			URL:             DiscoveryURL,
+			RefreshInterval: Duration{DiscoveryRefreshInterval},
+			Override:        APIOverride,
+			Mirrors:         APIMirrors,
+		},
+		Downloads: DownloadsConfig{
+			Workers:          M3U8Workers,
+			ReorderWindow:    M3U8ReorderWindow,
+			MaxConcurrent:    MaxConcurrentDownloads,
+			Connections:      MP4Connections,
+			PreferredSources: []string{},
+		},
+		Library: LibraryConfig{MovieTemplate: MovieTemplate, EpisodeTemplate: EpisodeTemplate},
+		API:     APIConfig{Mode: APIMode, Fixtures: FixturesDir},
+		Outbound: OutboundConfig{
+			Schemes:        OutboundSchemes,
+			AllowPrivate:   OutboundAllowPrivate,
+			SheetHostsOnly: OutboundSheetHostsOnly,
+		},
+	}
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir, _ = os.UserHomeDir()
+	}
+	return filepath.Join(dir, "Xaladownloader", "config.toml")
+}
+
+// Réglage modifiable par variable d'environnement et par option de ligne de commande
+type configSetting struct {
+	flag, env, usage string
+	boolean          bool
+	set              func(c *Config, v string) error
+}
+
+func setInt(dst *int) func(*Config, string) error {
+	return func(_ *Config, v string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("nombre entier attendu")
+		}
+		*dst = n
+		return nil
+	}
+}
+
+func setBool(dst *bool) func(*Config, string) error {
+	return func(_ *Config, v string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("booléen attendu (true ou false)")
+		}
+		*dst = b
+		return nil
+	}
+}
+
+// splitList découpe une liste "a,b" en ignorant les éléments vides.
+func splitList(v string) []string {
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func setString(dst *string) func(*Config, string) error {
+	return func(_ *Config, v string) error {
+		*dst = v
+		return nil
+	}
+}
+
+// configSettings décrit les réglages surchargeables de c. Les noms des
+// variables historiques (IS_DOCKER, M3U8_WORKERS...) sont conservés.
+func configSettings(c *Config) []configSetting {
+	return []configSetting{
+		{flag: "host", env: "XALA_HOST", usage: "adresse d'écoute (vide = toutes les interfaces)", set: setString(&c.Server.Host)},
+		{flag: "port", env: "XALA_PORT", usage: "port d'écoute", set: setInt(&c.Server.Port)},
+		{flag: "open-browser", env: "XALA_OPEN_BROWSER", usage: "ouvrir le navigateur au démarrage", boolean: true, set: setBool(&c.Server.OpenBrowser)},
+		{flag: "docker", env: "IS_DOCKER", usage: "mode Docker : téléchargements serveur désactivés", boolean: true, set: setBool(&c.Server.Docker)},
+		{flag: "update-url", env: "XALA_UPDATE_URL", usage: "URL du fichier de mise à jour", set: setString(&c.Updates.URL)},
+		{flag: "update-channel", env: "XALA_UPDATE_CHANNEL", usage: "canal de mise à jour : stable ou beta", set: setString(&c.Updates.Channel)},
+		{flag: "discovery-url", env: "XALA_DISCOVERY_URL", usage: "page donnant l'adresse actuelle de l'API", set: setString(&c.Discovery.URL)},
+		{flag: "refresh-interval", env: "XALA_REFRESH_INTERVAL", usage: "intervalle de rafraîchissement de l'adresse de l'API (ex : 6h)", set: func(c *Config, v string) error {
+			return c.Discovery.RefreshInterval.UnmarshalText([]byte(v))
+		}},
+		{flag: "api-url", env: "XALA_API_URL", usage: "adresse de l'API imposée (essayée en premier)", set: setString(&c.Discovery.Override)},
+		{flag: "mirrors", env: "XALA_API_MIRRORS", usage: "adresses de secours de l'API, séparées par des virgules", set: func(c *Config, v string) error {
+			c.Discovery.Mirrors = splitList(v)
+			return nil
+		}},
+		{flag: "workers", env: "M3U8_WORKERS", usage: "segments M3U8 téléchargés en parallèle", set: setInt(&c.Downloads.Workers)},
+		{flag: "reorder-window", env: "M3U8_REORDER_WINDOW", usage: "segments gardés en mémoire avant écriture", set: setInt(&c.Downloads.ReorderWindow)},
+		{flag: "max-downloads", env: "MAX_DOWNLOADS", usage: "téléchargements simultanés", set: setInt(&c.Downloads.MaxConcurrent)},
+		{flag: "connections", env: "MP4_CONNECTIONS", usage: "connexions parallèles par téléchargement MP4", set: setInt(&c.Downloads.Connections)},
+		{flag: "sources", env: "PREFERRED_SOURCES", usage: "sources préférées, par ordre de priorité (ex : vidzy,voe)", set: func(c *Config, v string) error {
+			c.Downloads.PreferredSources = parseSourcePreferences(v)
+			return nil
+		}},
+		{flag: "library", env: "LIBRARY_ROOT", usage: "dossier racine de la bibliothèque (vide = ~/Downloads)", set: setString(&c.Library.Root)},
+		{flag: "movie-template", env: "MOVIE_TEMPLATE", usage: "modèle de nom des films", set: setString(&c.Library.MovieTemplate)},
+		{flag: "episode-template", env: "EPISODE_TEMPLATE", usage: "modèle de nom des épisodes", set: setString(&c.Library.EpisodeTemplate)},
+		{flag: "api-mode", env: "XALA_API_MODE", usage: "live, record (enregistre les réponses de l'API) ou replay (hors ligne)", set: setString(&c.API.Mode)},
+		{flag: "fixtures", env: "XALA_FIXTURES", usage: "dossier des réponses enregistrées de l'API", set: setString(&c.API.Fixtures)},
+		{flag: "outbound-schemes", env: "XALA_OUTBOUND_SCHEMES", usage: "schémas autorisés pour les URLs à vérifier ou télécharger (ex : https)", set: func(c *Config, v string) error {
+			c.Outbound.Schemes = splitList(v)
+			return nil
+		}},
+		{flag: "allow-private", env: "XALA_ALLOW_PRIVATE", usage: "autoriser les URLs vers localhost et les réseaux privés", boolean: true, set: setBool(&c.Outbound.AllowPrivate)},
+		{flag: "sheet-hosts-only", env: "XALA_SHEET_HOSTS_ONLY", usage: "n'accepter que les hôtes présents dans une fiche servie", boolean: true, set: setBool(&c.Outbound.SheetHostsOnly)},
+	}
+}
+
+// LoadConfig construit la configuration effective à partir du fichier
+// (-config, XALA_CONFIG ou emplacement par défaut), de l'environnement et des
+// options args. Toutes les erreurs sont renvoyées ensemble.
+func LoadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	settings := configSettings(cfg)
+
+	// Les options sont lues d'abord (pour connaître -config) mais appliquées en dernier
+	fs := flag.NewFlagSet("xaladownloader", flag.ContinueOnError)
+	configPath := fs.String("config", "", "fichier de configuration TOML (défaut : "+defaultConfigPath()+")")
+	type flagValue struct {
+		setting configSetting
+		value   string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		record := func(v string) error {
+			flagValues = append(flagValues, flagValue{s, v})
+			return nil
+		}
+		if s.boolean {
+			fs.BoolFunc(s.flag, s.usage+" (env "+s.env+")", record)
+		} else {
+			fs.Func(s.flag, s.usage+" (env "+s.env+")", record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+
+	// 1. Fichier de configuration
+	path, required := *configPath, true
+	if path == "" {
+		path = os.Getenv("XALA_CONFIG")
+	}
+	if path == "" {
+		path, required = defaultConfigPath(), false
+	}
+	if _, err := os.Stat(path); err == nil || required {
+		md, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s : %v", path, err))
+		} else {
+			cfg.File = path
+			for _, key := range md.Undecoded() {
+				errs = append(errs, fmt.Errorf("%s : clé inconnue %q", path, key.String()))
+			}
+		}
+	}
+
+	// 2. Variables d'environnement
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.set(cfg, v); err != nil {
+				errs = append(errs, fmt.Errorf("variable %s=%q : %v", s.env, v, err))
+			}
+		}
+	}
+
+	// 3. Options de ligne de commande
+	for _, f := range flagValues {
+		if err := f.setting.set(cfg, f.value); err != nil {
+			errs = append(errs, fmt.Errorf("option -%s=%q : %v", f.setting.flag, f.value, err))
+		}
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func validateHTTPURL(name, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s : URL http(s) invalide %q", name, raw)
+	}
+	return nil
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port : %d hors de la plage 1-65535", c.Server.Port))
+	}
+	if c.Server.Host != "" && net.ParseIP(c.Server.Host) == nil && c.Server.Host != "localhost" {
+		errs = append(errs, fmt.Errorf("server.host : adresse IP invalide %q", c.Server.Host))
+	}
+	if err := validateHTTPURL("updates.url", c.Updates.URL); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Updates.Channel != ChannelStable && c.Updates.Channel != ChannelBeta {
+		errs = append(errs, fmt.Errorf("updates.channel : %q inconnu (stable ou beta)", c.Updates.Channel))
+	}
+	if err := validateHTTPURL("discovery.url", c.Discovery.URL); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Discovery.Override != "" {
+		if err := validateHTTPURL("discovery.override", c.Discovery.Override); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, m := range c.Discovery.Mirrors {
+		if err := validateHTTPURL("discovery.mirrors", m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Discovery.RefreshInterval.Duration < time.Minute {
+		errs = append(errs, fmt.Errorf("discovery.refresh_interval : %s trop court (minimum 1m)", c.Discovery.RefreshInterval))
+	}
+	if c.Downloads.Workers < 1 {
+		errs = append(errs, fmt.Errorf("downloads.workers : doit être d'au moins 1 (reçu %d)", c.Downloads.Workers))
+	}
+	if c.Downloads.ReorderWindow < 1 {
+		errs = append(errs, fmt.Errorf("downloads.reorder_window : doit être d'au moins 1 (reçu %d)", c.Downloads.ReorderWindow))
+	}
+	if c.Downloads.MaxConcurrent < 1 {
+		errs = append(errs, fmt.Errorf("downloads.max_concurrent : doit être d'au moins 1 (reçu %d)", c.Downloads.MaxConcurrent))
+	}
+	if c.Downloads.Connections < 1 || c.Downloads.Connections > 16 {
+		errs = append(errs, fmt.Errorf("downloads.connections : doit être entre 1 et 16 (reçu %d)", c.Downloads.Connections))
+	}
+	if strings.TrimSpace(c.Library.MovieTemplate) == "" {
+		errs = append(errs, fmt.Errorf("library.movie_template : modèle vide"))
+	}
+	if strings.TrimSpace(c.Library.EpisodeTemplate) == "" {
+		errs = append(errs, fmt.Errorf("library.episode_template : modèle vide"))
+	}
+	switch c.API.Mode {
+	case APIModeLive, APIModeRecord, APIModeReplay:
+	default:
+		errs = append(errs, fmt.Errorf("api.mode : %q inconnu (live, record ou replay)", c.API.Mode))
+	}
+	if c.API.Mode != APIModeLive && strings.TrimSpace(c.API.Fixtures) == "" {
+		errs = append(errs, fmt.Errorf("api.fixtures : dossier requis en mode %s", c.API.Mode))
+	}
+	if len(c.Outbound.Schemes) == 0 {
+		errs = append(errs, fmt.Errorf("outbound.schemes : au moins un schéma requis"))
+	}
+	for _, s := range c.Outbound.Schemes {
+		if s != "http" && s != "https" {
+			errs = append(errs, fmt.Errorf("outbound.schemes : %q non pris en charge (http ou https)", s))
+		}
+	}
+	return errs
+}
+
+// apply reporte la configuration dans les variables utilisées par le reste du programme.
+func (c *Config) apply() {
+	appConfig = c
+	isDocker = c.Server.Docker
+	UpdateConfigURL = c.Updates.URL
+	UpdateChannel = c.Updates.Channel
+	DiscoveryURL = c.Discovery.URL
+	DiscoveryRefreshInterval = c.Discovery.RefreshInterval.Duration
+	APIOverride = c.Discovery.Override
+	APIMirrors = c.Discovery.Mirrors
+	M3U8Workers = c.Downloads.Workers
+	M3U8ReorderWindow = c.Downloads.ReorderWindow
+	MaxConcurrentDownloads = c.Downloads.MaxConcurrent
+	MP4Connections = c.Downloads.Connections
+	// Même normalisation (casse, espaces) que pour l'environnement et les flags
+	PreferredSources = parseSourcePreferences(strings.Join(c.Downloads.PreferredSources, ","))
+	LibraryRoot = c.Library.Root
+	MovieTemplate = c.Library.MovieTemplate
+	EpisodeTemplate = c.Library.EpisodeTemplate
+	APIMode = c.API.Mode
+	FixturesDir = c.API.Fixtures
+	OutboundSchemes = c.Outbound.Schemes
+	OutboundAllowPrivate = c.Outbound.AllowPrivate
+	OutboundSheetHostsOnly = c.Outbound.SheetHostsOnly
+	api = NewPurstreamClient(BaseURL).withFixtures(APIMode, FixturesDir)
+}
+
+// ListenAddr renvoie l'adresse d'écoute du serveur HTTP.
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Server.Host, strconv.Itoa(c.Server.Port))
+}
+
+// LocalURL renvoie l'adresse à ouvrir dans le navigateur.
+func (c *Config) LocalURL() string {
+	host := c.Server.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(c.Server.Port))
+}
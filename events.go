@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types d'événements poussés sur /api/events
+const (
+	EventQueued    = "queued"
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventRetrying  = "retrying"
+	EventPaused    = "paused"
+	EventResumed   = "resumed"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	EventCancelled = "cancelled"
+)
+
+// Event décrit un changement dans la vie d'un job. Job est une copie de
+// l'état complet au moment de l'événement (progression, vitesse, statut...).
+type Event struct {
+	ID      int64     `json:"id"`
+	Type    string    `json:"type"`
+	Job     Job       `json:"job"`
+	Segment string    `json:"segment,omitempty"` // segment concerné par un retry
+	Attempt int       `json:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Diffusion des événements à tous les abonnés, sans jamais bloquer le moteur
+// de téléchargement. Un abonné trop lent perd les événements de progression ;
+// s'il ne peut plus recevoir un changement d'état, il est déconnecté (canal
+// fermé) pour se reconnecter et repartir d'un snapshot à jour.
+type EventBus struct {
+	mu     sync.Mutex
+	nextID int64
+	subs   map[chan Event]struct{}
+}
+
+var events = &EventBus{subs: make(map[chan Event]struct{})}
+
+func (b *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e.ID = b.nextID
+	e.Time = time.Now()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			if droppable(e.Type) {
+				continue
+			}
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// droppable indique les événements qu'un abonné en retard peut perdre : le
+// suivant (ou le snapshot d'une reconnexion) les remplace.
+func droppable(eventType string) bool {
+	return eventType == EventProgress || eventType == EventRetrying
+}
+
+// eventForState associe un état de job à l'événement correspondant.
+func eventForState(from, to JobState) string {
+	switch to {
+	case JobQueued:
+		return EventQueued
+	case JobRunning:
+		if from == JobPaused {
+			return EventResumed
+		}
+		return EventStarted
+	case JobPaused:
+		return EventPaused
+	case JobCompleted:
+		return EventCompleted
+	case JobFailed:
+		return EventFailed
+	default:
+		return EventCancelled
+	}
+}
+
+/*
+Flux Server-Sent Events des téléchargements : /api/events (?job=<id> pour suivre un seul job).
+À la connexion, un événement "snapshot" donne l'état de tous les jobs, puis chaque
+changement est poussé en direct. Utilisable depuis le navigateur (EventSource) ou curl -N.
+*/
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming non supporté", 500)
+		return
+	}
+	filter := r.URL.Query().Get("job")
+
+	ch := events.Subscribe()
+	defer events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	snapshot := jobs.List()
+	if filter != "" {
+		snapshot = []Job{}
+		if j, ok := jobs.Get(filter); ok {
+			snapshot = append(snapshot, j)
+		}
+	}
+	data, _ := json.Marshal(snapshot)
+	// retry : délai de reconnexion du navigateur, par exemple après une déconnexion pour lenteur
+	fmt.Fprintf(w, "retry: 1000\nevent: snapshot\ndata: %s\n\n", data)
+	flusher.Flush()
+
+	// Commentaire périodique pour garder la connexion ouverte derrière un proxy
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				return // abonné déconnecté car trop lent : le navigateur se reconnecte
+			}
+			if filter != "" && e.Job.ID != filter {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Modes du client de l'API (réglables : api.mode et api.fixtures)
+//   - live   : appels normaux
+//   - record : appels normaux, chaque réponse est enregistrée dans FixturesDir
+//   - replay : aucune requête réseau, les réponses sont relues depuis FixturesDir
+const (
+	APIModeLive   = "live"
+	APIModeRecord = "record"
+	APIModeReplay = "replay"
+)
+
+var (
+	APIMode     = APIModeLive
+	FixturesDir = "fixtures"
+)
+
+// Adresse factice de l'API en mode replay (l'hôte est ignoré par le rejeu)
+const replayBaseURL = "http://fixtures.invalid"
+
+func replaying() bool { return APIMode == APIModeReplay }
+
+// Réponse enregistrée. Le corps JSON est gardé tel quel pour rester lisible
+// et modifiable à la main ; un corps non JSON est stocké comme texte.
+type fixture struct {
+	Request     string          `json:"request"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType,omitempty"`
+	Text        bool            `json:"text,omitempty"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// fixtureTransport enregistre ou rejoue les réponses de l'API.
+type fixtureTransport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+}
+
+// withFixtures branche l'enregistrement ou le rejeu sur le client.
+func (c *PurstreamClient) withFixtures(mode, dir string) *PurstreamClient {
+	if mode != APIModeLive {
+		c.http.Transport = &fixtureTransport{mode: mode, dir: dir, next: c.http.Transport}
+	}
+	return c
+}
+
+var unsafeFixtureChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// fixtureKey identifie une requête indépendamment de l'hôte de l'API, qui change souvent.
+func fixtureKey(r *http.Request) string {
+	key := r.Method + " " + r.URL.EscapedPath()
+	if q := r.URL.Query(); len(q) > 0 {
+		key += "?" + q.Encode() // paramètres triés
+	}
+	return key
+}
+
+// fixturePath donne un nom de fichier lisible et unique pour une requête,
+// ex : GET /api/v1/media/42/sheet -> media_42_sheet-1a2b3c4d.json
+func (t *fixtureTransport) fixturePath(r *http.Request, key string) string {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	if r.URL.RawQuery != "" {
+		name += "_" + r.URL.Query().Encode()
+	}
+	name = strings.Trim(unsafeFixtureChars.ReplaceAllString(name, "_"), "_")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(t.dir, name+"-"+hex.EncodeToString(sum[:4])+".json")
+}
+
+func (t *fixtureTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	key := fixtureKey(r)
+	path := t.fixturePath(r, key)
+	if t.mode == APIModeReplay {
+		return t.replay(r, key, path)
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.record(key, path, resp, body); err != nil {
+		return nil, fmt.Errorf("enregistrement de %s : %v", key, err)
+	}
+	return resp, nil
+}
+
+func (t *fixtureTransport) record(key, path string, resp *http.Response, body []byte) error {
+	f := fixture{
+		Request:     key,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}
+	if !json.Valid(body) {
+		f.Text = true
+		f.Body, _ = json.Marshal(string(body))
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (t *fixtureTransport) replay(r *http.Request, key, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("aucune réponse enregistrée pour %s (%s)", key, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s : %v", path, err)
+	}
+
+	body := []byte(f.Body)
+	if f.Text {
+		var text string
+		if err := json.Unmarshal(f.Body, &text); err != nil {
+			return nil, fmt.Errorf("%s : %v", path, err)
+		}
+		body = []byte(text)
+	}
+	header := http.Header{}
+	if f.ContentType != "" {
+		header.Set("Content-Type", f.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useReplayAPI fait passer l'API par les réponses enregistrées de testdata/fixtures
+// (générées en mode record) : aucune requête réseau n'est faite.
+func useReplayAPI(t *testing.T) {
+	t.Helper()
+	prev := api
+	api = NewPurstreamClient(func() string { return replayBaseURL }).withFixtures(APIModeReplay, "testdata/fixtures")
+	t.Cleanup(func() { api = prev })
+}
+
+func TestReplaySearchAndEpisodes(t *testing.T) {
+	useReplayAPI(t)
+
+	// Recherche
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/api/search?q=breaking%20bad", nil))
+	if rec.Code != 200 {
+		t.Fatalf("recherche : statut %d, attendu 200 (%s)", rec.Code, rec.Body)
+	}
+	var results []Media
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != 7 || results[0].Title != "Breaking Bad" || results[0].Runtime != "47 min" {
+		t.Fatalf("résultats inattendus : %+v", results)
+	}
+
+	// Épisodes du premier résultat
+	rec = httptest.NewRecorder()
+	episodesHandler(rec, httptest.NewRequest("GET", "/api/episodes?id=7&num=1", nil))
+	if rec.Code != 200 {
+		t.Fatalf("épisodes : statut %d, attendu 200 (%s)", rec.Code, rec.Body)
+	}
+	var season SeasonDetailResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &season); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range season.Data.Items.Episodes {
+		names = append(names, e.Name)
+	}
+	if got := strings.Join(names, ", "); got != "Chute libre, Le Choix, Dérapage" {
+		t.Errorf("épisodes : %s", got)
+	}
+}
+
+func TestReplayMediaDetail(t *testing.T) {
+	useReplayAPI(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/media/{id}", mediaHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/media/7", nil))
+	if rec.Code != 200 {
+		t.Fatalf("statut %d, attendu 200 (%s)", rec.Code, rec.Body)
+	}
+	var detail MediaDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Seasons) != 1 || len(detail.Seasons[0].Episodes) != 3 {
+		t.Fatalf("saisons inattendues : %+v", detail.Seasons)
+	}
+	if ep := detail.Seasons[0].Episodes[1]; ep.Number != 2 || ep.Name != "Le Choix" {
+		t.Errorf("épisode 2 : %+v", ep)
+	}
+}
+
+func TestReplayRecordedNotFound(t *testing.T) {
+	useReplayAPI(t)
+
+	// Un 404 enregistré est rejoué comme tel
+	if _, err := api.Season(context.Background(), "7", 9); !errors.Is(err, ErrNotFound) {
+		t.Errorf("saison absente : %v, attendu ErrNotFound", err)
+	}
+
+	// Une requête jamais enregistrée échoue sans toucher au réseau
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/api/search?q=inconnu", nil))
+	if rec.Code != 502 {
+		t.Errorf("recherche non enregistrée : statut %d, attendu 502", rec.Code)
+	}
+}
@@ -2,7 +2,10 @@ module Xaladownloader
 
 go 1.25.1
 
-require github.com/PuerkitoBio/goquery v1.11.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/PuerkitoBio/goquery v1.11.0
+)
 
 require (
 	github.com/andybalholm/cascadia v1.3.3 // indirect
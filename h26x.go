@@ -0,0 +1,246 @@
+package main
+
+import "fmt"
+
+// Lecture minimale des SPS H.264 / H.265 : dimensions de l'image et champs
+// nécessaires aux boîtes avcC / hvcC.
+
+type spsInfo struct {
+	width, height     int
+	chromaFormat      int
+	bitDepthLuma      int // moins 8
+	bitDepthChroma    int // moins 8
+	profileTierLevel  []byte
+	maxSubLayers      int
+	temporalIDNesting bool
+}
+
+func (t *remuxTrack) videoInfo() spsInfo {
+	if t.streamType == streamTypeH265 {
+		return parseH265SPS(t.sps)
+	}
+	return parseH264SPS(t.sps)
+}
+
+// checkParamSets vérifie que les jeux de paramètres sont assez longs pour
+// construire avcC / hvcC : un flux corrompu donne une erreur, pas un panic.
+func (t *remuxTrack) checkParamSets() error {
+	if t.streamType == streamTypeH265 {
+		if len(t.sps) < 3 || len(removeEmulationPrevention(t.sps[2:])) < 13 {
+			return fmt.Errorf("SPS H.265 tronqué (%d octets)", len(t.sps))
+		}
+	} else if len(t.sps) < 4 {
+		return fmt.Errorf("SPS H.264 tronqué (%d octets)", len(t.sps))
+	}
+	if len(t.pps) < 2 {
+		return fmt.Errorf("PPS tronqué (%d octets)", len(t.pps))
+	}
+	return nil
+}
+
+// Lecteur de bits avec codes Exp-Golomb. Une lecture au-delà de la fin
+// renvoie des zéros, ce qui suffit pour des SPS tronqués.
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bitReader) bit() int {
+	if b.pos >= len(b.data)*8 {
+		b.pos++
+		return 0
+	}
+	v := int(b.data[b.pos/8]>>(7-uint(b.pos%8))) & 1
+	b.pos++
+	return v
+}
+
+func (b *bitReader) bits(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | b.bit()
+	}
+	return v
+}
+
+func (b *bitReader) skip(n int) { b.pos += n }
+
+func (b *bitReader) ue() int {
+	zeros := 0
+	for b.bit() == 0 && zeros < 32 {
+		zeros++
+	}
+	return (1<<zeros - 1) + b.bits(zeros)
+}
+
+func (b *bitReader) se() int {
+	v := b.ue()
+	if v%2 == 0 {
+		return -v / 2
+	}
+	return (v + 1) / 2
+}
+
+// removeEmulationPrevention retire les octets 0x03 insérés après 00 00.
+func removeEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, c := range nal {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func parseH264SPS(nal []byte) spsInfo {
+	info := spsInfo{chromaFormat: 1}
+	if len(nal) < 4 {
+		return info
+	}
+	b := &bitReader{data: removeEmulationPrevention(nal[1:])}
+	profile := b.bits(8)
+	b.skip(16) // contraintes + level
+	b.ue()     // seq_parameter_set_id
+
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		info.chromaFormat = b.ue()
+		if info.chromaFormat == 3 {
+			b.skip(1)
+		}
+		info.bitDepthLuma = b.ue()
+		info.bitDepthChroma = b.ue()
+		b.skip(1)
+		if b.bit() == 1 { // seq_scaling_matrix_present_flag
+			lists := 8
+			if info.chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if b.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + b.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	b.ue() // log2_max_frame_num_minus4
+	switch b.ue() {
+	case 0:
+		b.ue()
+	case 1:
+		b.skip(1)
+		b.se()
+		b.se()
+		// Borné par la fin des données : un SPS tronqué lit des zéros à l'infini
+		for n := b.ue(); n > 0 && b.pos < len(b.data)*8; n-- {
+			b.se()
+		}
+	}
+	b.ue()
+	b.skip(1)
+	widthMbs := b.ue() + 1
+	heightMaps := b.ue() + 1
+	frameMbsOnly := b.bit()
+	if frameMbsOnly == 0 {
+		b.skip(1)
+	}
+	b.skip(1)
+
+	var cropL, cropR, cropT, cropB int
+	if b.bit() == 1 {
+		cropL, cropR, cropT, cropB = b.ue(), b.ue(), b.ue(), b.ue()
+	}
+	cropX, cropY := 1, 2-frameMbsOnly
+	switch info.chromaFormat {
+	case 1:
+		cropX, cropY = 2, 2*(2-frameMbsOnly)
+	case 2:
+		cropX = 2
+	}
+	info.width = widthMbs*16 - cropX*(cropL+cropR)
+	info.height = (2-frameMbsOnly)*heightMaps*16 - cropY*(cropT+cropB)
+	return info
+}
+
+func parseH265SPS(nal []byte) spsInfo {
+	info := spsInfo{chromaFormat: 1}
+	if len(nal) < 16 {
+		return info
+	}
+	rbsp := removeEmulationPrevention(nal[2:])
+	if len(rbsp) < 13 { // les octets 0x03 retirés peuvent raccourcir le SPS
+		return info
+	}
+	b := &bitReader{data: rbsp}
+	b.skip(4) // sps_video_parameter_set_id
+	maxSub := b.bits(3)
+	info.maxSubLayers = maxSub + 1
+	info.temporalIDNesting = b.bit() == 1
+
+	// general_profile_tier_level : 12 octets recopiés tels quels dans hvcC
+	info.profileTierLevel = append([]byte(nil), rbsp[1:13]...)
+	b.skip(96)
+
+	subProfile := make([]bool, maxSub)
+	subLevel := make([]bool, maxSub)
+	for i := 0; i < maxSub; i++ {
+		subProfile[i] = b.bit() == 1
+		subLevel[i] = b.bit() == 1
+	}
+	if maxSub > 0 {
+		b.skip(2 * (8 - maxSub))
+	}
+	for i := 0; i < maxSub; i++ {
+		if subProfile[i] {
+			b.skip(88)
+		}
+		if subLevel[i] {
+			b.skip(8)
+		}
+	}
+
+	b.ue() // sps_seq_parameter_set_id
+	info.chromaFormat = b.ue()
+	if info.chromaFormat == 3 {
+		b.skip(1)
+	}
+	width, height := b.ue(), b.ue()
+	if b.bit() == 1 { // conformance_window_flag
+		subW, subH := 1, 1
+		switch info.chromaFormat {
+		case 1:
+			subW, subH = 2, 2
+		case 2:
+			subW = 2
+		}
+		l, r, t, bo := b.ue(), b.ue(), b.ue(), b.ue()
+		width -= subW * (l + r)
+		height -= subH * (t + bo)
+	}
+	info.width, info.height = width, height
+	info.bitDepthLuma = b.ue()
+	info.bitDepthChroma = b.ue()
+	return info
+}
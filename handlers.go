@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
 func searchHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
-	res, _ := fetchMedia(r.Context(), q)
+	res, err := api.Search(r.Context(), q)
+	if err != nil {
+		log.Printf("Erreur API Search: %v", err)
+		http.Error(w, "Erreur API externe", 502)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
@@ -22,42 +32,12 @@ Handler pour récupérer les dernières sorties (films/séries récemment ajout
 L'UI peut afficher ces éléments dans une section "Dernières sorties" ou similaire.
 */
 func lastReleasesHandler(w http.ResponseWriter, r *http.Request) {
-	remote := fmt.Sprintf("%s/api/v1/last-released-movies/13", BaseURL)
-	req, _ := http.NewRequestWithContext(r.Context(), "GET", remote, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	finalResults, err := api.LastReleases(r.Context(), 13)
 	if err != nil {
+		log.Printf("Erreur API LastReleases: %v", err)
 		http.Error(w, "Erreur API externe", 502)
 		return
 	}
-	defer resp.Body.Close()
-
-	// L'API renvoie désormais un tableau d'items directement dans Data
-	var apiData struct {
-		Data struct {
-			Items []PurestreamMovie `json:"items"`
-		} `json:"data"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&apiData); err != nil {
-		log.Printf("Erreur décodage LastReleases: %v", err)
-		http.Error(w, "Erreur décodage JSON", 500)
-		return
-	}
-
-	finalResults := []Media{}
-	for _, item := range apiData.Data.Items {
-		finalResults = append(finalResults, Media{
-			Title:    item.Title,
-			ID:       item.ID,
-			ThumbURL: item.LargePosterPath, // Correction ici
-			Kind:     item.Type,
-			Runtime:  fmt.Sprintf("%d min", item.Runtime), // Conversion int -> string
-			Updated:  item.UpdatedAt,
-		})
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(finalResults)
@@ -73,37 +53,12 @@ func franchiseHandler(w http.ResponseWriter, r *http.Request) {
 		franchiseID = "30" // Par défaut Prime Video
 	}
 
-	remote := fmt.Sprintf("%s/api/v1/franchise/%s", BaseURL, franchiseID)
-	req, _ := http.NewRequestWithContext(r.Context(), "GET", remote, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
+	finalResults, err := api.Franchise(r.Context(), franchiseID)
 	if err != nil {
+		log.Printf("Erreur API Franchise: %v", err)
 		http.Error(w, "Erreur API Franchise", 502)
 		return
 	}
-	defer resp.Body.Close()
-
-	var apiData FranchiseAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiData); err != nil {
-		log.Printf("Erreur décodage précise : %v", err)
-		http.Error(w, "Erreur décodage Franchise", 500)
-		return
-	}
-
-	finalResults := []Media{}
-	for _, item := range apiData.Data.Items.Franchise.Movies.Items {
-		finalResults = append(finalResults, Media{
-			Title:    item.Title,
-			ID:       item.ID,
-			ThumbURL: item.LargePosterPath, // Utilise le nouveau champ
-			Kind:     item.Type,
-			// Conversion de l'int runtime en string pour rester compatible avec ton type Media
-			Runtime: fmt.Sprintf("%d min", item.Runtime),
-			Updated: item.UpdatedAt,
-		})
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(finalResults)
@@ -115,23 +70,23 @@ L'UI envoie l'ID du média et le numéro de saison, et ce handler interroge l'AP
 */
 func episodesHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
-	season := r.URL.Query().Get("num")
-
-	// On force le formatage "01" pour l'API si nécessaire
-	remote := fmt.Sprintf("%s/api/v1/media/%s/season/%s", BaseURL, id, season)
-
-	req, _ := http.NewRequest("GET", remote, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
+	season, err := strconv.Atoi(r.URL.Query().Get("num"))
+	if id == "" || err != nil {
+		http.Error(w, "Paramètres id et num requis", 400)
+		return
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	episodes, err := api.Season(r.Context(), id, season)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	defer resp.Body.Close()
 
+	// Même forme que la réponse de l'API, que l'UI consommait telle quelle
+	var data SeasonDetailResponse
+	data.Data.Items.Episodes = episodes
 	w.Header().Set("Content-Type", "application/json")
-	io.Copy(w, resp.Body)
+	json.NewEncoder(w).Encode(data)
 }
 
 /*
@@ -149,16 +104,16 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// --- ÉTAPE 1 : Récupération de la Sheet ---
-	remote := fmt.Sprintf("%s/api/v1/media/%s/sheet", BaseURL, detailID)
-	resp, err := http.Get(remote)
+	sheet, err := api.Sheet(r.Context(), detailID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Média introuvable", 404)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Erreur API Sheet", 502)
 		return
 	}
-	defer resp.Body.Close()
-
-	var sheet SheetResponse
-	json.NewDecoder(resp.Body).Decode(&sheet)
+	sheetHosts.Remember(sheet.Data.Items.Urls)
 
 	// --- ÉTAPE 2 : Mode Info (Renvoi de la liste à l'UI) ---
 	if infoOnly && selectedURL == "" {
@@ -178,6 +133,12 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Aucune URL valide trouvée", 404)
 		return
 	}
+	if selectedURL != "" {
+		if err := checkOutboundURL(r.Context(), selectedURL); err != nil {
+			http.Error(w, err.Error(), 403)
+			return
+		}
+	}
 
 	// --- ÉTAPE 4 : Validation et Adaptation (MP4 vs M3U8) ---
 	if strings.Contains(targetURL, ".m3u8") {
@@ -189,35 +150,72 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// --- ÉTAPE 5 : Proxy de téléchargement pour MP4 ---
-	downloadFileProxy(w, targetURL, sheet.Data.Items.Title)
+	// Le navigateur choisit le dossier, mais le nom suit le modèle de la bibliothèque
+	info := parseMediaInfo(r.URL.Query())
+	info.Title = sheet.Data.Items.Title
+	if info.Year == "" {
+		info.Year = yearOf(sheet.Data.Items.ReleaseDate)
+	}
+	downloadFileProxy(w, r, targetURL, filepath.Base(info.outputBase()))
 }
 
 /*
 Proxy de téléchargement : Ce handler agit comme un intermédiaire pour télécharger le fichier depuis l'URL source et le servir directement à l'utilisateur,
 tout en gérant les headers pour la progression et le nom de fichier.
 */
-func downloadFileProxy(w http.ResponseWriter, targetURL string, title string) {
-	// 1. On récupère le fichier source
-	res, err := http.Get(targetURL)
+func downloadFileProxy(w http.ResponseWriter, r *http.Request, targetURL string, title string) {
+	// 1. On récupère le fichier source, en relayant Range / If-Range pour la reprise et la recherche dans la vidéo
+	req, err := http.NewRequestWithContext(r.Context(), "GET", targetURL, nil)
+	if err != nil {
+		http.Error(w, "URL source invalide", 400)
+		return
+	}
+	for _, h := range []string{"Range", "If-Range"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	res, err := outboundStreamHTTP.Do(req)
 	if err != nil {
 		http.Error(w, "Erreur lors de la récupération du fichier", 502)
 		return
 	}
 	defer res.Body.Close()
 
-	// 2. IMPORTANT : On transfère la taille du fichier pour la barre de progression
-	if contentLength := res.Header.Get("Content-Length"); contentLength != "" {
-		w.Header().Set("Content-Length", contentLength)
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		if cr := res.Header.Get("Content-Range"); cr != "" {
+			w.Header().Set("Content-Range", cr)
+		}
+		http.Error(w, "Plage demandée invalide", http.StatusRequestedRangeNotSatisfiable)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("Source indisponible (statut HTTP %d)", res.StatusCode), 502)
+		return
+	}
+
+	// 2. IMPORTANT : On transfère la taille du fichier pour la barre de progression,
+	// ainsi que les en-têtes de plage et de validation de la source
+	for _, h := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if v := res.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
 	}
 
 	// 3. Autoriser le JS à lire les headers (pour XMLHttpRequest)
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Disposition")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Disposition, Content-Range, Accept-Ranges, ETag")
 
 	filename := sanitizeFileName(title) + ".mp4"
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	w.Header().Set("Content-Type", "video/mp4")
+	contentType := res.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+	w.Header().Set("Content-Type", contentType)
 
-	// 4. On stream le contenu
+	// 4. On stream le contenu (200 ou 206 selon la source)
+	w.WriteHeader(res.StatusCode)
 	io.Copy(w, res.Body)
 }
 
@@ -226,6 +224,19 @@ Handler pour lancer le téléchargement d'un flux M3U8.
 L'UI envoie l'URL du flux et le titre pour le nom de fichier.
 */
 func m3u8Handler(w http.ResponseWriter, r *http.Request) {
+	enqueueDownload(w, r, KindM3U8)
+}
+
+/*
+Handler pour télécharger côté serveur une source MP4 directe (au lieu du proxy /api/download) :
+même file, mêmes paramètres de nommage que /api/m3u8-download, plusieurs connexions si la source accepte les plages.
+*/
+func mp4Handler(w http.ResponseWriter, r *http.Request) {
+	enqueueDownload(w, r, KindMP4)
+}
+
+// enqueueDownload crée un job à partir des paramètres url / title et le place dans la file.
+func enqueueDownload(w http.ResponseWriter, r *http.Request, kind string) {
 	streamURL := r.URL.Query().Get("url")
 	title := r.URL.Query().Get("title")
 
@@ -233,17 +244,147 @@ func m3u8Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Paramètres manquants", 400)
 		return
 	}
+	if err := checkOutboundURL(r.Context(), streamURL); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	opts, err := parseM3U8Options(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	job := jobs.Create(title, streamURL)
+
+	// Le job part dans la file : il démarre dès qu'une place se libère
+	// (contexte indépendant de la requête : le téléchargement lui survit, il s'arrête via /api/jobs/{id}/cancel)
+	downloadQueue.Add(queueEntry{
+		JobID:   job.ID,
+		Title:   title,
+		URL:     streamURL,
+		Kind:    kind,
+		Options: opts,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// parseM3U8Options lit les options communes aux téléchargements M3U8 :
+// format=mp4|ts, le choix de variante (variant=best|worst|height|bandwidth + height / maxBandwidth)
+// et les métadonnées du nom de fichier (voir parseMediaInfo).
+func parseM3U8Options(q url.Values) (M3U8Options, error) {
+	// Format de sortie : "mp4" (par défaut, remuxé) ou "ts" (segments bruts)
+	container := q.Get("format")
+	if container == "" {
+		container = ContainerMP4
+	}
+	if container != ContainerMP4 && container != ContainerTS {
+		return M3U8Options{}, fmt.Errorf("Format inconnu (mp4 ou ts)")
+	}
+	policy, err := parseVariantPolicy(q)
+	if err != nil {
+		return M3U8Options{}, err
+	}
+	return M3U8Options{Container: container, Variant: policy, Media: parseMediaInfo(q)}, nil
+}
 
-	// On lance le téléchargement dans une Goroutine pour ne pas bloquer le navigateur
-	go func() {
-		err := DownloadM3U8(streamURL, title)
-		if err != nil {
-			fmt.Println("Erreur M3U8:", err)
+/*
+Structure normalisée d'un média : /api/media/{id}
+Saisons -> épisodes (avec leur nom) -> sources, reconstruites côté serveur à partir de la fiche.
+*/
+func mediaHandler(w http.ResponseWriter, r *http.Request) {
+	detail, err := buildMediaDetail(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Média introuvable", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Erreur API Sheet", 502)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+/*
+Téléchargement groupé d'une saison ou de toute une série : /api/batch-download?id=123&season=1 (ou season=all).
+Pour chaque épisode de la fiche, la meilleure source M3U8 en ligne est choisie selon les préférences
+(?sources=nom1,nom2, sinon PREFERRED_SOURCES) et un job "Titre S01E02" est mis en file.
+Les options format / variant de /api/m3u8-download s'appliquent à tous les épisodes.
+*/
+func batchDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mediaID := q.Get("id")
+	if mediaID == "" {
+		http.Error(w, "ID manquant", 400)
+		return
+	}
+
+	season := 0 // 0 = toutes les saisons
+	if s := q.Get("season"); s != "" && s != "all" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "Saison invalide (numéro ou all)", 400)
+			return
 		}
-	}()
+		season = n
+	}
+	opts, err := parseM3U8Options(q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	prefs := PreferredSources
+	if s := q.Get("sources"); s != "" {
+		prefs = parseSourcePreferences(s)
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Téléchargement lancé"))
+	result, err := queueSeriesBatch(r.Context(), mediaID, season, prefs, opts)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Média introuvable", 404)
+		return
+	case errors.Is(err, ErrNoEpisodes):
+		http.Error(w, err.Error(), 404)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 502)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runDownloadJob exécute un job de la file (M3U8 ou MP4) et reporte son issue dans le registre.
+func runDownloadJob(e queueEntry) {
+	id := e.JobID
+	ctx, finish, err := jobs.Start(context.Background(), id)
+	if err != nil {
+		return // annulé avant d'avoir démarré
+	}
+	defer finish()
+
+	download := DownloadM3U8
+	if e.Kind == KindMP4 {
+		download = DownloadMP4
+	}
+	err = download(ctx, id, e.URL, e.Title, e.Options)
+	switch {
+	case ctx.Err() != nil:
+		// Annulation demandée : le fichier partiel est gardé pour une reprise ou supprimé
+		if job, ok := jobs.Get(id); ok && job.basePath != "" && !jobs.keepPartial(id) {
+			removePartial(job.basePath)
+		}
+	case err != nil:
+		log.Printf("Erreur de téléchargement (%s) : %v", e.Title, err)
+		jobs.Fail(id, err)
+	default:
+		if err := jobs.Transition(id, JobCompleted, "Terminé ! (Vérifiez vos Téléchargements)"); err != nil {
+			log.Printf("Téléchargement %s terminé mais job non clôturé : %v", e.Title, err)
+			jobs.Fail(id, err)
+		}
+	}
 }
 
 /*
@@ -251,10 +392,17 @@ Vérification de l'URL pour éliminer les liens morts avant de lancer le téléc
 */
 func checkURLHandler(w http.ResponseWriter, r *http.Request) {
 	target := r.URL.Query().Get("url")
-	client := &http.Client{Timeout: 3 * time.Second}
+	if err := checkOutboundURL(r.Context(), target); err != nil {
+		http.Error(w, err.Error(), 403)
+		return
+	}
+	client := &http.Client{Timeout: 3 * time.Second, Transport: outboundTransport, CheckRedirect: checkOutboundRedirect}
 
 	// On utilise HEAD pour ne pas consommer de bande passante
 	resp, err := client.Head(target)
+	if err == nil {
+		defer resp.Body.Close()
+	}
 
 	status := "ok"
 	if err != nil || resp.StatusCode >= 400 {
@@ -264,83 +412,196 @@ func checkURLHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": status})
 }
 
-var m3u8Progress = make(map[string]string)
-
 /*
 Handler pour vérifier le statut du téléchargement M3U8 en cours.
-L'UI peut interroger cette route pour afficher une progression ou un message d'état.
+Conservé pour compatibilité : renvoie le job le plus récent portant ce titre (préférer /api/jobs/{id}).
 */
 func m3u8StatusHandler(w http.ResponseWriter, r *http.Request) {
 	title := r.URL.Query().Get("title")
-	status, ok := m3u8Progress[title]
+	job, ok := jobs.LatestByTitle(title)
 	if !ok {
-		status = "Aucun téléchargement en cours"
+		json.NewEncoder(w).Encode(map[string]string{"status": "Aucun téléchargement en cours"})
+		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{"status": status})
+	json.NewEncoder(w).Encode(map[string]any{"status": job.Status, "variant": job.Variant, "job": job})
 }
 
 /*
-Handler pour récupérer le catalogue par type (movie, tv, anime).
-Exemple : /api/catalog?type=anime
+Liste de tous les téléchargements suivis par le serveur.
 */
-func catalogHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. Récupération et validation du type et de la page demandés
-	contentType := r.URL.Query().Get("type")
-	if contentType == "" {
-		contentType = "movie" // Défaut
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs.List())
+}
+
+/*
+Actions sur un téléchargement : /api/jobs/{id}/{action}
+  - cancel (?keepPartial=true pour garder le fichier partiel et pouvoir reprendre plus tard)
+  - pause
+  - resume
+*/
+func jobActionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := jobs.Get(id); !ok {
+		http.Error(w, "Job introuvable", 404)
+		return
 	}
 
-	page := r.URL.Query().Get("page")
-	if page == "" {
-		page = "1"
+	var err error
+	switch r.PathValue("action") {
+	case "cancel":
+		err = jobs.Cancel(id, r.URL.Query().Get("keepPartial") == "true")
+		if err == nil {
+			downloadQueue.Remove(id)
+		}
+	case "pause":
+		err = jobs.Pause(id)
+	case "resume":
+		err = jobs.Resume(id)
+	default:
+		http.Error(w, "Action inconnue", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 409)
+		return
 	}
 
-	// 2. Construction de l'URL avec les paramètres demandés
-	// Note: On utilise la variable globale BaseURL mise à jour par ton refresher
-	remote := fmt.Sprintf("%s/api/v1/catalog/movies?sortBy=best-rated&types=%s&perPage=100&page=%s", BaseURL, contentType, page)
+	job, _ := jobs.Get(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
 
-	req, _ := http.NewRequestWithContext(r.Context(), "GET", remote, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
+/*
+Détail d'un téléchargement : /api/jobs/{id}
+*/
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job introuvable", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+/*
+État de la file de téléchargement : limite, jobs en cours et jobs en attente (dans l'ordre de lancement).
+*/
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downloadQueue.Snapshot())
+}
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
+/*
+Priorité d'un job en attente : /api/queue/{id}/move?position=0 (0 = prochain lancé)
+*/
+func queueMoveHandler(w http.ResponseWriter, r *http.Request) {
+	position, err := strconv.Atoi(r.URL.Query().Get("position"))
 	if err != nil {
-		log.Printf("Erreur API Catalog: %v", err)
-		http.Error(w, "Erreur lors de l'appel à l'API source", 502)
+		http.Error(w, "Position invalide", 400)
+		return
+	}
+	if err := downloadQueue.Move(r.PathValue("id"), position); err != nil {
+		http.Error(w, err.Error(), 404)
 		return
 	}
-	defer resp.Body.Close()
+	queueHandler(w, r)
+}
 
-	// 3. Structure correspondant exactement au JSON fourni
-	var apiResponse struct {
-		Data struct {
-			Items struct {
-				Data []PurestreamMovie `json:"data"` // Le tableau est ici
-			} `json:"items"`
-		} `json:"data"`
+/*
+Limite de téléchargements simultanés : /api/queue/config?maxConcurrent=3
+*/
+func queueConfigHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("maxConcurrent"))
+	if err != nil {
+		http.Error(w, "maxConcurrent invalide", 400)
+		return
 	}
+	if err := downloadQueue.SetMaxConcurrent(n); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	queueHandler(w, r)
+}
+
+/*
+État de la découverte de l'adresse de l'API : adresse actuelle, son origine
+(override, saved, wiki, mirror) et le résultat de chaque candidate essayée.
+*/
+func discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discovery.Status())
+}
+
+/*
+État de la mise à jour : version disponible, notes, installation possible.
+*/
+func updateStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UpdateState())
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		log.Printf("Erreur décodage Catalog: %v", err)
-		http.Error(w, "Erreur format de données", 500)
+/*
+Installe la mise à jour disponible (active au prochain démarrage).
+Désactivé en mode Docker.
+*/
+func updateApplyHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := ApplyUpdate()
+	switch {
+	case errors.Is(err, ErrSelfUpdateDisabled):
+		http.Error(w, err.Error(), 403)
+		return
+	case errors.Is(err, ErrNoUpdate), errors.Is(err, ErrUpdateBusy):
+		http.Error(w, err.Error(), 409)
+		return
+	case err != nil:
+		http.Error(w, "Mise à jour impossible : "+err.Error(), 502)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UpdateState())
+}
+
+/*
+Configuration effective du serveur (fichier + environnement + options).
+isDocker reste à la racine pour l'UI.
+*/
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"isDocker": isDocker,
+		"version":  CurrentVersion,
+		"config":   appConfig,
+	})
+}
 
-	// 4. Transformation vers ton type interne 'Media' (comme dans tes autres handlers)
-	finalResults := []Media{}
-	for _, item := range apiResponse.Data.Items.Data {
-		finalResults = append(finalResults, Media{
-			Title:    item.Title,
-			ID:       item.ID,
-			ThumbURL: item.LargePosterPath,
-			Kind:     item.Type,
-			Runtime:  fmt.Sprintf("%d min", item.Runtime),
-			Updated:  item.UpdatedAt,
-		})
+/*
+Handler pour récupérer le catalogue par type (movie, tv, anime).
+Exemple : /api/catalog?type=anime
+*/
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. Récupération et validation du type et de la page demandés
+	contentType := r.URL.Query().Get("type")
+	if contentType == "" {
+		contentType = "movie" // Défaut
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// 2. Appel de l'API source
+	finalResults, err := api.Catalog(r.Context(), contentType, page)
+	if err != nil {
+		log.Printf("Erreur API Catalog: %v", err)
+		http.Error(w, "Erreur lors de l'appel à l'API source", 502)
+		return
 	}
 
-	// 5. Envoi de la réponse à l'UI
+	// 3. Envoi de la réponse à l'UI
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(finalResults)
 }
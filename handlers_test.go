@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchHandler(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{results: []Media{{ID: 42, Title: "Inception", Kind: "movie"}}})
+
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/api/search?q=incep", nil))
+	if rec.Code != 200 {
+		t.Fatalf("statut %d, attendu 200", rec.Code)
+	}
+	var got []Media
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != 42 || got[0].Title != "Inception" {
+		t.Errorf("résultats inattendus : %+v", got)
+	}
+}
+
+func TestSearchHandlerUpstreamError(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{err: &APIError{Endpoint: "/api/v1/search-bar/search/x", Status: 500, Err: errors.New("statut HTTP 500")}})
+
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/api/search?q=x", nil))
+	if rec.Code != 502 {
+		t.Errorf("statut %d, attendu 502", rec.Code)
+	}
+}
+
+func TestEpisodesHandler(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{seasons: map[string][]Episode{"7/1": {{Number: 1, Name: "Pilote"}, {Number: 2, Name: "Suite"}}}})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"saison connue", "?id=7&num=1", 200},
+		{"numéro manquant", "?id=7", 400},
+		{"id manquant", "?num=1", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			episodesHandler(rec, httptest.NewRequest("GET", "/api/episodes"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("statut %d, attendu %d", rec.Code, tt.status)
+			}
+			if tt.status != 200 {
+				return
+			}
+			// Même forme que la réponse de l'API, consommée telle quelle par l'UI
+			var got SeasonDetailResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if eps := got.Data.Items.Episodes; len(eps) != 2 || eps[0].Name != "Pilote" {
+				t.Errorf("épisodes inattendus : %+v", eps)
+			}
+		})
+	}
+}
+
+func TestMediaHandler(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{
+		sheets: map[string]SheetResponse{"7": sheetOf(7, "Série",
+			"https://cdn.example.com/serie/S01E01/index.m3u8",
+			"https://cdn.example.com/serie/S01E02/index.m3u8",
+		)},
+		seasons: map[string][]Episode{"7/1": {{Number: 1, Name: "Pilote"}}},
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/media/{id}", mediaHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/media/7", nil))
+	if rec.Code != 200 {
+		t.Fatalf("statut %d, attendu 200", rec.Code)
+	}
+	var got MediaDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Seasons) != 1 || len(got.Seasons[0].Episodes) != 2 {
+		t.Fatalf("saisons inattendues : %+v", got.Seasons)
+	}
+	if name := got.Seasons[0].Episodes[0].Name; name != "Pilote" {
+		t.Errorf("nom de l'épisode 1 : %q, attendu \"Pilote\"", name)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/media/404", nil))
+	if rec.Code != 404 {
+		t.Errorf("média inconnu : statut %d, attendu 404", rec.Code)
+	}
+}
+
+func TestDownloadHandlerNotFound(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{})
+
+	rec := httptest.NewRecorder()
+	downloadHandler(rec, httptest.NewRequest("GET", "/api/download?detail=404&infoOnly=true", nil))
+	if rec.Code != 404 {
+		t.Errorf("statut %d, attendu 404", rec.Code)
+	}
+}
+
+func TestBatchDownloadHandlerNoEpisode(t *testing.T) {
+	useFakeAPI(t, &fakeAPI{sheets: map[string]SheetResponse{
+		"9": sheetOf(9, "Film", "https://cdn.example.com/film/index.m3u8"),
+	}})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"saison sans épisode", "?id=9&season=1", 404},
+		{"média inconnu", "?id=404&season=1", 404},
+		{"saison invalide", "?id=9&season=0", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			batchDownloadHandler(rec, httptest.NewRequest("POST", "/api/batch-download"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Errorf("statut %d, attendu %d : %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
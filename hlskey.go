@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"sync"
+)
+
+// Cache des clés AES-128 d'un téléchargement. Les clés sont récupérées avec le
+// même client HTTP et les mêmes en-têtes que les segments, une seule fois par URI
+// (la rotation de clé produit simplement plusieurs entrées).
+type hlsKeyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (c *hlsKeyCache) get(f *segmentFetcher, uri string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[uri]; ok {
+		return key, nil
+	}
+	key, err := f.fetch(uri, nil, "clé "+uri)
+	if err != nil {
+		return nil, fmt.Errorf("récupération de la clé impossible : %v", err)
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("clé AES-128 invalide (%d octets)", len(key))
+	}
+	c.keys[uri] = key
+	return key, nil
+}
+
+// decrypt déchiffre un segment AES-128 (CBC + padding PKCS#7).
+func (c *hlsKeyCache) decrypt(f *segmentFetcher, seg hlsSegment, data []byte) ([]byte, error) {
+	key, err := c.get(f, seg.Key.URI)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("segment chiffré de taille invalide (%d octets)", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, seg.segmentIV()).CryptBlocks(data, data)
+
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(data) {
+		return nil, fmt.Errorf("padding PKCS#7 invalide")
+	}
+	return data[:len(data)-pad], nil
+}
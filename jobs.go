@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// États d'un téléchargement
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobPaused    JobState = "paused"
+	JobFailed    JobState = "failed"
+	JobCompleted JobState = "completed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Transitions autorisées de la machine à états
+var jobTransitions = map[JobState][]JobState{
+	JobQueued:    {JobRunning, JobCancelled, JobFailed},
+	JobRunning:   {JobPaused, JobFailed, JobCompleted, JobCancelled},
+	JobPaused:    {JobRunning, JobCancelled, JobFailed, JobCompleted}, // pause pendant la finalisation : le fichier est déjà écrit
+	JobFailed:    {JobQueued},
+	JobCancelled: {JobQueued},
+	JobCompleted: {},
+}
+
+func (s JobState) canMoveTo(to JobState) bool {
+	for _, allowed := range jobTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal indique que le job ne bougera plus sans action de l'utilisateur.
+func (s JobState) Terminal() bool {
+	return s == JobFailed || s == JobCompleted || s == JobCancelled
+}
+
+// Job décrit un téléchargement suivi par le serveur.
+type Job struct {
+	ID            string      `json:"id"`
+	Title         string      `json:"title"`
+	URL           string      `json:"url"`
+	State         JobState    `json:"state"`
+	Status        string      `json:"status"` // message lisible pour l'UI
+	SegmentsDone  int         `json:"segmentsDone"`
+	SegmentsTotal int         `json:"segmentsTotal"`
+	Bytes         int64       `json:"bytes"`
+	BytesTotal    int64       `json:"bytesTotal,omitempty"`
+	Speed         float64     `json:"speed"` // octets par seconde
+	ETA           float64     `json:"eta"`   // secondes restantes estimées
+	OutputPath    string      `json:"outputPath,omitempty"`
+	Variant       *HLSVariant `json:"variant,omitempty"`
+	LastError     string      `json:"lastError,omitempty"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+
+	// Chemin de sortie sans extension, connu une fois la variante choisie
+	basePath string
+
+	// Échantillon pour le calcul de la vitesse
+	speedAt    time.Time
+	speedBytes int64
+}
+
+// Pilotage d'un job en cours d'exécution
+type jobControl struct {
+	cancel      context.CancelFunc
+	gate        *pauseGate
+	keepPartial bool // à l'annulation, garder le fichier partiel pour une reprise
+}
+
+// Registre des jobs, protégé par un mutex : toutes les lectures renvoient des
+// copies et toutes les écritures passent par update.
+type JobRegistry struct {
+	mu       sync.RWMutex
+	jobs     map[string]*Job
+	order    []string // ordre de création
+	controls map[string]*jobControl
+	outputs  map[string]string // chemin de sortie (sans extension) -> job qui l'écrit
+}
+
+var jobs = &JobRegistry{jobs: make(map[string]*Job), controls: make(map[string]*jobControl), outputs: make(map[string]string)}
+
+// ErrOutputBusy signale un chemin de sortie déjà en cours d'écriture par un autre job.
+var ErrOutputBusy = errors.New("fichier déjà en cours de téléchargement")
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create enregistre un nouveau job en attente.
+func (r *JobRegistry) Create(title, url string) Job {
+	now := time.Now()
+	j := &Job{
+		ID:        newJobID(),
+		Title:     title,
+		URL:       url,
+		State:     JobQueued,
+		Status:    "En attente",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	r.mu.Lock()
+	r.jobs[j.ID] = j
+	r.order = append(r.order, j.ID)
+	r.mu.Unlock()
+
+	events.Publish(Event{Type: EventQueued, Job: *j})
+	return *j
+}
+
+// Restore réenregistre un job sauvegardé dans la file avant un redémarrage.
+func (r *JobRegistry) Restore(id, title, url string) Job {
+	now := time.Now()
+	j := &Job{
+		ID:        id,
+		Title:     title,
+		URL:       url,
+		State:     JobQueued,
+		Status:    "En attente (reprise après redémarrage)",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	r.mu.Lock()
+	if existing, ok := r.jobs[id]; ok {
+		r.mu.Unlock()
+		return *existing
+	}
+	r.jobs[id] = j
+	r.order = append(r.order, id)
+	r.mu.Unlock()
+
+	events.Publish(Event{Type: EventQueued, Job: *j})
+	return *j
+}
+
+func (r *JobRegistry) Get(id string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List renvoie les jobs dans l'ordre de création.
+func (r *JobRegistry) List() []Job {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Job, 0, len(r.order))
+	for _, id := range r.order {
+		list = append(list, *r.jobs[id])
+	}
+	return list
+}
+
+// LatestByTitle renvoie le job le plus récent portant ce titre.
+func (r *JobRegistry) LatestByTitle(title string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.order) - 1; i >= 0; i-- {
+		if j := r.jobs[r.order[i]]; j.Title == title {
+			return *j, true
+		}
+	}
+	return Job{}, false
+}
+
+// update modifie un job sous verrou et renvoie une copie du résultat. Un ID
+// vide ou inconnu est ignoré, ce qui permet d'utiliser le moteur de
+// téléchargement hors du serveur.
+func (r *JobRegistry) update(id string, fn func(j *Job)) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	fn(j)
+	j.UpdatedAt = time.Now()
+	return *j, true
+}
+
+// ClaimOutput réserve le chemin de sortie basePath pour le job id jusqu'à la
+// fin de son exécution : deux jobs pour le même média (double clic, file et
+// lancement manuel) écriraient sinon le même fichier .part et le même manifeste.
+func (r *JobRegistry) ClaimOutput(id, basePath string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil // téléchargement non suivi
+	}
+	if owner, busy := r.outputs[basePath]; busy && owner != id {
+		return fmt.Errorf("%w par le job %s : %s", ErrOutputBusy, owner, filepath.Base(basePath))
+	}
+	r.outputs[basePath] = id
+	j.basePath = basePath
+	return nil
+}
+
+// Transition fait passer un job dans un nouvel état si la machine à états l'autorise.
+func (r *JobRegistry) Transition(id string, to JobState, status string) error {
+	r.mu.Lock()
+	j, ok := r.jobs[id]
+	if !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("job %s introuvable", id)
+	}
+	from := j.State
+	if !from.canMoveTo(to) {
+		r.mu.Unlock()
+		return fmt.Errorf("transition %s -> %s impossible", from, to)
+	}
+	j.State = to
+	j.UpdatedAt = time.Now()
+	if status != "" {
+		j.Status = status
+	}
+	if to != JobRunning {
+		j.Speed, j.ETA = 0, 0
+	}
+	snapshot := *j
+	r.mu.Unlock()
+
+	events.Publish(Event{Type: eventForState(from, to), Job: snapshot})
+	return nil
+}
+
+// Fail marque un job en échec avec son erreur.
+func (r *JobRegistry) Fail(id string, err error) {
+	failed := false
+	j, ok := r.update(id, func(j *Job) {
+		if j.State.Terminal() {
+			return
+		}
+		failed = true
+		j.State = JobFailed
+		j.LastError = err.Error()
+		j.Status = "Erreur : " + err.Error()
+		j.Speed, j.ETA = 0, 0
+	})
+	if ok && failed {
+		events.Publish(Event{Type: EventFailed, Job: j, Error: err.Error()})
+	}
+}
+
+func (r *JobRegistry) SetStatus(id, status string) {
+	if j, ok := r.update(id, func(j *Job) { j.Status = status }); ok {
+		events.Publish(Event{Type: EventProgress, Job: j})
+	}
+}
+
+// sampleSpeed met à jour la vitesse (moyenne glissante, échantillon d'au moins une seconde).
+func (j *Job) sampleSpeed(bytes int64) {
+	now := time.Now()
+	if j.speedAt.IsZero() {
+		j.speedAt, j.speedBytes = now, bytes
+		return
+	}
+	if dt := now.Sub(j.speedAt).Seconds(); dt >= 1 {
+		instant := float64(bytes-j.speedBytes) / dt
+		if j.Speed == 0 {
+			j.Speed = instant
+		} else {
+			j.Speed = 0.7*j.Speed + 0.3*instant
+		}
+		j.speedAt, j.speedBytes = now, bytes
+	}
+}
+
+// Progress met à jour l'avancement, la vitesse (moyenne glissante) et l'ETA.
+func (r *JobRegistry) Progress(id string, done, total int, bytes int64) {
+	j, ok := r.update(id, func(j *Job) {
+		j.sampleSpeed(bytes)
+		j.SegmentsDone, j.SegmentsTotal, j.Bytes = done, total, bytes
+		j.Status = fmt.Sprintf("Téléchargement : %d/%d segments", done, total)
+		// Estimation : taille moyenne d'un segment x segments restants / vitesse
+		if done > 0 && j.Speed > 0 {
+			remaining := float64(bytes) / float64(done) * float64(total-done)
+			j.ETA = remaining / j.Speed
+		}
+	})
+	if ok {
+		events.Publish(Event{Type: EventProgress, Job: j})
+	}
+}
+
+// ProgressChunks met à jour l'avancement d'un téléchargement découpé en
+// morceaux dont la taille totale est connue (size, 0 si inconnue).
+func (r *JobRegistry) ProgressChunks(id string, done, total int, bytes, size int64) {
+	j, ok := r.update(id, func(j *Job) {
+		j.sampleSpeed(bytes)
+		j.SegmentsDone, j.SegmentsTotal, j.Bytes, j.BytesTotal = done, total, bytes, size
+		j.Status = fmt.Sprintf("Téléchargement : %d/%d morceaux", done, total)
+		if size > 0 && j.Speed > 0 {
+			j.ETA = float64(size-bytes) / j.Speed
+		}
+	})
+	if ok {
+		events.Publish(Event{Type: EventProgress, Job: j})
+	}
+}
+
+// Retrying signale qu'un segment va être retenté après une erreur.
+func (r *JobRegistry) Retrying(id, segment string, attempt int, err error) {
+	if j, ok := r.Get(id); ok {
+		events.Publish(Event{Type: EventRetrying, Job: j, Segment: segment, Attempt: attempt, Error: err.Error()})
+	}
+}
+
+// Barrière de pause : Wait bloque tant que le job est en pause.
+// Une barrière nil n'est jamais en pause.
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // nil = pas en pause, fermé à la reprise
+}
+
+func (g *pauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// Paused indique si la barrière est fermée, sans attendre.
+func (g *pauseGate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+func (g *pauseGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return ctx.Err()
+	}
+	g.mu.Lock()
+	ch := g.resumed
+	g.mu.Unlock()
+	if ch == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ch:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start passe le job en cours d'exécution et renvoie le contexte à donner au
+// moteur de téléchargement. finish doit être appelé quand le moteur a rendu la main.
+func (r *JobRegistry) Start(parent context.Context, id string) (ctx context.Context, finish func(), err error) {
+	ctx, cancel := context.WithCancel(parent)
+	// Le pilotage est enregistré avant la transition pour qu'une pause ou une
+	// annulation immédiate ne soit jamais perdue.
+	r.mu.Lock()
+	r.controls[id] = &jobControl{cancel: cancel, gate: &pauseGate{}}
+	r.mu.Unlock()
+	if err := r.Transition(id, JobRunning, "Initialisation..."); err != nil {
+		cancel()
+		r.mu.Lock()
+		delete(r.controls, id)
+		r.mu.Unlock()
+		return nil, nil, err
+	}
+
+	finish = func() {
+		cancel()
+		r.mu.Lock()
+		delete(r.controls, id)
+		if j, ok := r.jobs[id]; ok && r.outputs[j.basePath] == id {
+			delete(r.outputs, j.basePath)
+		}
+		r.mu.Unlock()
+	}
+	return ctx, finish, nil
+}
+
+// gate renvoie la barrière de pause d'un job (nil si le job n'est pas piloté).
+func (r *JobRegistry) gate(id string) *pauseGate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.controls[id]; ok {
+		return c.gate
+	}
+	return nil
+}
+
+// keepPartial indique si le fichier partiel doit être conservé après annulation.
+func (r *JobRegistry) keepPartial(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.controls[id]
+	return ok && c.keepPartial
+}
+
+// Cancel annule un job en attente, en cours ou en pause.
+func (r *JobRegistry) Cancel(id string, keepPartial bool) error {
+	status := "Annulé"
+	if keepPartial {
+		status = "Annulé (fichier partiel conservé)"
+	}
+	if err := r.Transition(id, JobCancelled, status); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.controls[id]; ok {
+		c.keepPartial = keepPartial
+		c.cancel()
+	}
+	return nil
+}
+
+// Pause suspend un job en cours : plus aucune connexion n'est ouverte
+// jusqu'à la reprise.
+func (r *JobRegistry) Pause(id string) error {
+	if err := r.Transition(id, JobPaused, "En pause"); err != nil {
+		return err
+	}
+	if g := r.gate(id); g != nil {
+		g.Pause()
+	}
+	return nil
+}
+
+func (r *JobRegistry) Resume(id string) error {
+	if err := r.Transition(id, JobRunning, "Reprise..."); err != nil {
+		return err
+	}
+	if g := r.gate(id); g != nil {
+		g.Resume()
+	}
+	return nil
+}
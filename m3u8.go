@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Nombre de segments téléchargés en parallèle et taille de la fenêtre de
+// réordonnancement (nombre max de segments gardés en mémoire avant écriture).
+var (
+	M3U8Workers       = 8
+	M3U8ReorderWindow = 32
+)
+
+// Affichage de la progression brute dans la console du serveur (le mode
+// ligne de commande affiche sa propre barre).
+var ConsoleProgress = true
+
+// Options d'un téléchargement M3U8
+type M3U8Options struct {
+	Container string        `json:"container"` // ContainerMP4 (remuxage) ou ContainerTS (segments bruts)
+	Variant   VariantPolicy `json:"variant"`   // choix de la variante dans une master playlist
+	Media     MediaInfo     `json:"media"`     // métadonnées pour le nom du fichier de sortie
+}
+
+// Résultat du téléchargement d'un segment, renvoyé par un worker à l'écrivain.
+type segmentResult struct {
+	index int
+	init  []byte // section d'initialisation fMP4 à écrire avant le segment
+	data  []byte
+	err   error
+}
+
+// Requêtes d'un téléchargement : client partagé, en-têtes, annulation (ctx)
+// et pause (gate, consultée avant chaque nouvelle connexion).
+type segmentFetcher struct {
+	ctx     context.Context
+	jobID   string
+	client  *http.Client
+	referer string
+	gate    *pauseGate
+}
+
+// removePartial supprime le fichier temporaire et le manifeste de reprise
+// d'un téléchargement M3U8 ou MP4 (basePath : chemin de sortie sans extension).
+func removePartial(basePath string) {
+	partPath, manifestPath := partPaths(basePath)
+	os.Remove(partPath)
+	os.Remove(manifestPath)
+}
+
+// startsMap indique si le segment i ouvre une section EXT-X-MAP : premier
+// segment du flux ou changement de section. Une reprise au milieu d'une
+// section ne réécrit donc pas l'init, déjà présente dans le fichier partiel.
+func startsMap(segments []hlsSegment, i int) bool {
+	return segments[i].Map != nil && (i == 0 || segments[i-1].Map != segments[i].Map)
+}
+
+// DownloadM3U8 télécharge un flux HLS. jobID désigne le job du registre à
+// tenir à jour (vide si le téléchargement n'est pas suivi). L'annulation de ctx
+// interrompt le téléchargement en laissant le fichier partiel reprenable.
+func DownloadM3U8(ctx context.Context, jobID string, targetURL string, fileName string, opts M3U8Options) error {
+	gate := jobs.gate(jobID)
+	if err := gate.Wait(ctx); err != nil {
+		return err
+	}
+	playlist, err := resolveM3U8(ctx, targetURL, opts.Variant)
+	if err != nil {
+		return err
+	}
+	segments := playlist.Segments
+	info := opts.Media
+	if info.Title == "" {
+		info.Title = fileName
+	}
+	if playlist.Variant != nil {
+		jobs.update(jobID, func(j *Job) { j.Variant = playlist.Variant })
+		log.Printf("Variante retenue pour %s : %s", fileName, playlist.Variant)
+		if info.Quality == "" && playlist.Variant.Height > 0 {
+			info.Quality = fmt.Sprintf("%dp", playlist.Variant.Height)
+		}
+	}
+
+	// Chemin de sortie selon les modèles de la bibliothèque
+	basePath := info.outputBase()
+	if err := os.MkdirAll(filepath.Dir(basePath), 0755); err != nil {
+		return err
+	}
+	if err := jobs.ClaimOutput(jobID, basePath); err != nil {
+		return err
+	}
+	// Les segments sont concaténés tels quels dans le fichier temporaire (TS, ou
+	// init + fragments pour du fMP4), le remuxage éventuel se fait à la fin.
+	partPath, manifestPath := partPaths(basePath)
+
+	// Reprise : si un manifeste correspond au même travail, on repart du
+	// dernier segment validé, sinon on recommence de zéro.
+	manifest, err := loadManifest(manifestPath)
+	uris := segmentURIs(segments)
+	if err != nil || !manifest.canResume(targetURL, uris) {
+		manifest = &m3u8Manifest{PlaylistURL: targetURL, Segments: uris, LastIndex: -1}
+	} else {
+		log.Printf("Reprise de %s au segment %d/%d", fileName, manifest.LastIndex+2, len(segments))
+	}
+
+	finalFile, err := openPartFile(partPath, manifest)
+	if err != nil {
+		return err
+	}
+	defer finalFile.Close()
+
+	// Sauvegarde du point de reprise : les données sont synchronisées sur
+	// disque avant le manifeste pour qu'il ne décrive jamais plus que le fichier.
+	checkpoint := func() error {
+		if err := finalFile.Sync(); err != nil {
+			return err
+		}
+		return saveManifest(manifestPath, manifest)
+	}
+	if err := checkpoint(); err != nil {
+		return err
+	}
+
+	total := len(segments)
+	start := manifest.LastIndex + 1
+	jobs.Progress(jobID, start, total, manifest.BytesWritten)
+	// Utilisation d'un Transport pour réutiliser les connexions (Keep-Alive)
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         outboundDialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: M3U8Workers,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: checkOutboundRedirect,
+	}
+	fetcher := &segmentFetcher{ctx: ctx, jobID: jobID, client: client, referer: targetURL, gate: gate}
+	keys := &hlsKeyCache{keys: make(map[string][]byte)}
+
+	workers := max(M3U8Workers, 1)
+	window := max(M3U8ReorderWindow, workers)
+
+	// La fenêtre borne le nombre de segments "en vol" (téléchargés mais pas
+	// encore écrits) : un slot est pris avant le téléchargement et rendu après
+	// l'écriture. La mémoire reste donc plafonnée quelle que soit la playlist.
+	slots := make(chan struct{}, window)
+	queue := make(chan int)
+	results := make(chan segmentResult, workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(queue)
+		for i := start; i < total; i++ {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case queue <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range queue {
+				seg := segments[i]
+				res := segmentResult{index: i}
+				// La section d'initialisation est écrite une fois, puis à
+				// chaque changement d'EXT-X-MAP (jamais de nouveau à la reprise).
+				if startsMap(segments, i) {
+					res.init, res.err = fetcher.fetch(seg.Map.URI, seg.Map.Range, "init "+seg.Map.URI)
+					if res.err == nil && seg.Map.Key != nil {
+						res.init, res.err = keys.decrypt(fetcher, *seg.Map, res.init)
+					}
+				}
+				if res.err == nil {
+					res.data, res.err = fetcher.fetch(seg.URI, seg.Range, fmt.Sprintf("segment %d", i))
+				}
+				if res.err == nil && seg.Key != nil {
+					res.data, res.err = keys.decrypt(fetcher, seg, res.data)
+				}
+				select {
+				case results <- res:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Écrivain : on garde les segments arrivés en avance jusqu'à ce que
+	// le suivant dans l'ordre de la playlist soit disponible.
+	pending := make(map[int]segmentResult, window)
+	next := start
+	for next < total {
+		select {
+		case res := <-results:
+			pending[res.index] = res
+		case <-ctx.Done():
+			// Annulation : on sauvegarde le point de reprise avant de sortir
+			checkpoint()
+			return ctx.Err()
+		}
+
+		for {
+			seg, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			if ctx.Err() != nil {
+				checkpoint()
+				return ctx.Err()
+			}
+			// Sans sa section d'initialisation, un flux fMP4 est illisible
+			if seg.init == nil && startsMap(segments, next) {
+				return fmt.Errorf("section d'initialisation fMP4 introuvable : %v", seg.err)
+			}
+			// L'init est écrite même si le segment échoue : les segments
+			// suivants de la même EXT-X-MAP (et une reprise) comptent dessus.
+			if _, err := finalFile.Write(seg.init); err != nil {
+				return err
+			}
+			manifest.BytesWritten += int64(len(seg.init))
+			if seg.err != nil {
+				log.Printf("\n[!] Échec définitif du segment %d après 5 tentatives", next)
+				// On peut choisir de continuer ou d'arrêter ici.
+				// Pour un film, continuer créera un petit "saut" dans la vidéo.
+			} else {
+				if _, err := finalFile.Write(seg.data); err != nil {
+					return err
+				}
+				manifest.BytesWritten += int64(len(seg.data))
+			}
+			manifest.LastIndex = next
+
+			jobs.Progress(jobID, next+1, total, manifest.BytesWritten)
+			if next%10 == 0 {
+				if ConsoleProgress {
+					fmt.Printf("\rProgression : %d/%d", next+1, total)
+				}
+				if err := checkpoint(); err != nil {
+					return err
+				}
+			}
+
+			next++
+			<-slots
+		}
+	}
+
+	// Fichier complet : on le renomme vers son nom définitif et on supprime le manifeste
+	if err := finalFile.Sync(); err != nil {
+		return err
+	}
+	finalFile.Close()
+	jobs.SetStatus(jobID, "Finalisation...")
+	outputPath, err := finalizeM3U8(partPath, basePath, fileName, opts.Container, playlist.FMP4)
+	if err != nil {
+		return err
+	}
+	os.Remove(manifestPath)
+
+	jobs.update(jobID, func(j *Job) { j.OutputPath = outputPath })
+	return nil
+}
+
+// finalizeM3U8 produit le fichier définitif à partir des segments concaténés et
+// renvoie son chemin. L'extension suit le conteneur réellement écrit : un flux
+// fMP4 est déjà un MP4 fragmenté lisible, et si le remuxage d'un TS échoue on
+// garde le flux brut en .ts plutôt que de tout perdre.
+func finalizeM3U8(partPath, basePath, fileName, container string, fmp4 bool) (string, error) {
+	if fmp4 {
+		if container == ContainerTS {
+			log.Printf("%s est un flux fMP4 : sortie en .mp4 (pas de TS possible)", fileName)
+		}
+		return basePath + ".mp4", os.Rename(partPath, basePath+".mp4")
+	}
+
+	tsPath := basePath + ".ts"
+	if container == ContainerTS {
+		return tsPath, os.Rename(partPath, tsPath)
+	}
+
+	mp4Path := basePath + ".mp4"
+	tmpPath := mp4Path + ".tmp"
+	if err := RemuxTSToMP4(partPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		log.Printf("Remuxage MP4 impossible pour %s (%v), conservation du flux TS", fileName, err)
+		return tsPath, os.Rename(partPath, tsPath)
+	}
+	if err := os.Rename(tmpPath, mp4Path); err != nil {
+		return "", err
+	}
+	os.Remove(partPath)
+	return mp4Path, nil
+}
+
+// sliceRange découpe la plage demandée si le serveur a ignoré l'en-tête Range
+// et renvoyé le fichier entier.
+func sliceRange(data []byte, rng *byteRange, status int) ([]byte, error) {
+	if rng == nil || status == 206 {
+		return data, nil
+	}
+	if rng.Offset+rng.Length > int64(len(data)) {
+		return nil, fmt.Errorf("plage %s hors du fichier (%d octets)", rng.header(), len(data))
+	}
+	return data[rng.Offset : rng.Offset+rng.Length], nil
+}
+
+// fetch télécharge un segment (ou une clé) en mémoire avec 5 essais.
+// Tant que le job est en pause, aucune nouvelle connexion n'est ouverte.
+func (f *segmentFetcher) fetch(segmentURL string, rng *byteRange, label string) ([]byte, error) {
+	var lastErr error
+	for retry := 0; retry < 5; retry++ {
+		if err := f.gate.Wait(f.ctx); err != nil {
+			return nil, err
+		}
+		req, _ := http.NewRequestWithContext(f.ctx, "GET", segmentURL, nil)
+		if err := checkProxiedRequest(req); err != nil {
+			return nil, err // URL lue dans la playlist : inutile de réessayer
+		}
+		if rng != nil {
+			req.Header.Set("Range", rng.header())
+		}
+
+		// CRUCIAL : On imite un vrai navigateur au maximum
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36")
+		req.Header.Set("Referer", f.referer) // Très souvent requis par les serveurs m3u8
+
+		resp, err := f.client.Do(req)
+		if err == nil {
+			if resp.StatusCode == 200 || (rng != nil && resp.StatusCode == 206) {
+				data, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil {
+					return sliceRange(data, rng, resp.StatusCode)
+				}
+				lastErr = err
+			} else {
+				resp.Body.Close() // Ne pas oublier de fermer même si erreur
+				lastErr = fmt.Errorf("statut HTTP %d", resp.StatusCode)
+			}
+		} else {
+			lastErr = err
+		}
+
+		if f.ctx.Err() != nil {
+			return nil, f.ctx.Err()
+		}
+		log.Printf("[!] Retry %d pour %s...", retry+1, label)
+		jobs.Retrying(f.jobID, label, retry+1, lastErr)
+		select {
+		case <-time.After(time.Duration(1<<retry) * 250 * time.Millisecond):
+		case <-f.ctx.Done():
+			return nil, f.ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
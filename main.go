@@ -1,19 +1,16 @@
 package main
 
 import (
-	"bufio"
-	"context"
 	"embed"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -38,28 +35,11 @@ var isDocker = false
 //go:embed ui
 var uiFiles embed.FS
 
-// URL vers un fichier JSON sur GitHub ou ton serveur
-const UpdateConfigURL = "https://raw.githubusercontent.com/RajareCorp/Xaladownloader/master/update.json"
+// URL vers un fichier JSON sur GitHub ou ton serveur (réglable : updates.url)
+var UpdateConfigURL = "https://raw.githubusercontent.com/RajareCorp/Xaladownloader/master/update.json"
 
 // --- Logique App ---
 
-func getEpisodes(ctx context.Context, mediaID int, seasonNum int) ([]Episode, error) {
-	remote := fmt.Sprintf("%s/api/v1/media/%d/season/%d", BaseURL, mediaID, seasonNum)
-	req, _ := http.NewRequestWithContext(ctx, "GET", remote, nil)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var data SeasonDetailResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, err
-	}
-	return data.Data.Items.Episodes, nil
-}
-
 func sanitizeFileName(name string) string {
 	return regexp.MustCompile(`[\\/:*?"<>|]`).ReplaceAllString(name, "")
 }
@@ -77,135 +57,58 @@ func openBrowser(url string) error {
 	return cmd.Start()
 }
 
-func DownloadM3U8(targetURL string, fileName string) error {
-	finalURL, segments, err := resolveM3U8(targetURL)
-	if err != nil {
-		return err
-	}
-
-	home, _ := os.UserHomeDir()
-	downloadPath := filepath.Join(home, "Downloads", sanitizeFileName(fileName)+".mp4")
-
-	finalFile, err := os.Create(downloadPath)
-	if err != nil {
-		return err
+func main() {
+	// Sonde utilisée après une mise à jour pour vérifier que le binaire démarre
+	if len(os.Args) == 2 && (os.Args[1] == "--version" || os.Args[1] == "-version") {
+		fmt.Println(CurrentVersion)
+		os.Exit(exitOK)
 	}
-	defer finalFile.Close()
-
-	total := len(segments)
-	// Utilisation d'un Transport pour réutiliser les connexions (Keep-Alive)
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			IdleConnTimeout:     90 * time.Second,
-			TLSHandshakeTimeout: 10 * time.Second,
-		},
+	// Sous-commandes (search, info, episodes, download, serve) ; sans
+	// sous-commande, on lance le serveur comme avant.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCLI(os.Args[1], os.Args[2:]))
 	}
-	baseURL, _ := url.Parse(finalURL)
-
-	for i, segLine := range segments {
-		m3u8Progress[fileName] = fmt.Sprintf("Téléchargement : %d/%d segments", i+1, total)
-
-		u, _ := url.Parse(segLine)
-		segmentURL := baseURL.ResolveReference(u).String()
-
-		var success bool
-		// Tentative avec 5 essais et une pause exponentielle
-		for retry := 0; retry < 5; retry++ {
-			req, _ := http.NewRequest("GET", segmentURL, nil)
-
-			// CRUCIAL : On imite un vrai navigateur au maximum
-			req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36")
-			req.Header.Set("Referer", targetURL) // Très souvent requis par les serveurs m3u8
-
-			resp, err := client.Do(req)
-
-			if err == nil {
-				if resp.StatusCode == 200 {
-					_, err = io.Copy(finalFile, resp.Body)
-					resp.Body.Close()
-					if err == nil {
-						success = true
-						break
-					}
-				} else {
-					resp.Body.Close() // Ne pas oublier de fermer même si erreur
-				}
-			}
-
-			log.Printf("[!] Retry %d pour segment %d...", retry+1, i)
-		}
+	os.Exit(serve(os.Args[1:]))
+}
 
-		if !success {
-			log.Printf("\n[!] Échec définitif du segment %d après 5 tentatives", i)
-			// On peut choisir de continuer ou d'arrêter ici.
-			// Pour un film, continuer créera un petit "saut" dans la vidéo.
-			continue
-		}
+// serve démarre l'interface web. args contient les options de configuration.
+func serve(args []string) int {
+	fmt.Println(developerTag)
+	fmt.Printf("Version actuelle: %s\n", CurrentVersion)
 
-		if i%10 == 0 {
-			fmt.Printf("\rProgression : %d/%d", i+1, total)
-		}
+	cfg, err := LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
 	}
-
-	finalFile.Sync()
-	m3u8Progress[fileName] = "Terminé ! (Vérifiez vos Téléchargements)"
-	return nil
-}
-
-// resolveM3U8 avec un buffer illimité pour les playlists géantes
-func resolveM3U8(uri string) (string, []string, error) {
-	resp, err := http.Get(uri)
 	if err != nil {
-		return "", nil, err
+		fmt.Fprintf(os.Stderr, "Configuration invalide :\n%v\n", err)
+		return exitUsage
 	}
-	defer resp.Body.Close()
-
-	var segments []string
-	baseURL, _ := url.Parse(uri)
-
-	// Utilisation de bufio.Reader au lieu de Scanner pour éviter la limite de ligne
-	reader := bufio.NewReader(resp.Body)
-	for {
-		line, err := reader.ReadString('\n')
-		line = strings.TrimSpace(line)
-
-		if line != "" && !strings.HasPrefix(line, "#") {
-			if strings.Contains(line, ".m3u8") {
-				nextURL := baseURL.ResolveReference(&url.URL{Path: line}).String()
-				return resolveM3U8(nextURL)
-			}
-			segments = append(segments, line)
-		}
-
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", nil, err
-		}
+	cfg.apply()
+	if cfg.File != "" {
+		fmt.Println("Configuration chargée :", cfg.File)
 	}
 
-	if len(segments) == 0 {
-		return "", nil, fmt.Errorf("aucune donnée trouvée")
+	if APIMode == APIModeRecord {
+		fmt.Println("Enregistrement des réponses de l'API dans", FixturesDir)
 	}
-	return uri, segments, nil
-}
-
-func main() {
-	fmt.Println(developerTag)
-	fmt.Printf("Version actuelle: %s\n", CurrentVersion)
-
-	if os.Getenv("IS_DOCKER") == "true" {
-		isDocker = true
+	if isDocker {
 		fmt.Println("⚠️ Mode Docker activé : Téléchargements limités.")
 	}
 
-	// Vérifier les mises à jour en arrière-plan ou au démarrage
-	CheckForUpdates()
+	// Vérifier les mises à jour en arrière-plan (pas de réseau en mode replay)
+	if !replaying() {
+		StartUpdateCheck()
+	}
 	InitApp()
 
+	// File de téléchargement persistante : les jobs interrompus par un arrêt reprennent
+	downloadQueue = NewDownloadQueue(defaultQueuePath(), MaxConcurrentDownloads)
+	if err := downloadQueue.Load(); err != nil {
+		log.Println(err)
+	}
+	downloadQueue.schedule()
+
 	// On extrait le sous-dossier "ui"
 	strippedFS, err := fs.Sub(uiFiles, "ui")
 	if err != nil {
@@ -233,6 +136,10 @@ func main() {
 	http.HandleFunc("/api/franchise", franchiseHandler)
 	http.HandleFunc("/api/catalog", catalogHandler)
 	http.HandleFunc("/api/check-url", checkURLHandler)
+	http.HandleFunc("GET /api/media/{id}", mediaHandler)
+	http.HandleFunc("GET /api/discovery", discoveryHandler)
+	http.HandleFunc("GET /api/update", updateStatusHandler)
+	http.HandleFunc("POST /api/update", updateApplyHandler)
 	http.HandleFunc("/api/m3u8-download", func(w http.ResponseWriter, r *http.Request) {
 		if isDocker {
 			http.Error(w, "Téléchargement interdit sur ce serveur", 403)
@@ -240,17 +147,44 @@ func main() {
 		}
 		m3u8Handler(w, r)
 	})
-	http.HandleFunc("/api/m3u8-status", m3u8StatusHandler)
-	http.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
-		json.NewEncoder(w).Encode(map[string]bool{"isDocker": isDocker})
+	http.HandleFunc("/api/mp4-download", func(w http.ResponseWriter, r *http.Request) {
+		if isDocker {
+			http.Error(w, "Téléchargement interdit sur ce serveur", 403)
+			return
+		}
+		mp4Handler(w, r)
 	})
+	http.HandleFunc("POST /api/batch-download", func(w http.ResponseWriter, r *http.Request) {
+		if isDocker {
+			http.Error(w, "Téléchargement interdit sur ce serveur", 403)
+			return
+		}
+		batchDownloadHandler(w, r)
+	})
+	http.HandleFunc("/api/m3u8-status", m3u8StatusHandler)
+	http.HandleFunc("GET /api/jobs", jobsHandler)
+	http.HandleFunc("GET /api/jobs/{id}", jobHandler)
+	http.HandleFunc("POST /api/jobs/{id}/{action}", jobActionHandler)
+	http.HandleFunc("GET /api/events", eventsHandler)
+	http.HandleFunc("GET /api/queue", queueHandler)
+	http.HandleFunc("POST /api/queue/config", queueConfigHandler)
+	http.HandleFunc("POST /api/queue/{id}/move", queueMoveHandler)
+	http.HandleFunc("/api/config", configHandler)
+
+	// On écoute avant d'ouvrir le navigateur : un port déjà pris est signalé clairement
+	listener, err := net.Listen("tcp", cfg.ListenAddr())
+	if err != nil {
+		log.Printf("Impossible d'écouter sur %s : %v", cfg.ListenAddr(), err)
+		return exitFailure
+	}
+	fmt.Println("Démarrage sur", cfg.LocalURL())
 
-	go func() {
-		fmt.Println("Démarrage sur http://127.0.0.1:8080")
-		http.ListenAndServe(":8080", nil)
-	}()
-
-	time.Sleep(500 * time.Millisecond)
-	openBrowser("http://127.0.0.1:8080")
-	select {}
+	if cfg.Server.OpenBrowser && !isDocker {
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			openBrowser(cfg.LocalURL())
+		}()
+	}
+	log.Println(http.Serve(listener, nil))
+	return exitFailure
 }
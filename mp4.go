@@ -0,0 +1,315 @@
+package main
+
+// Construction des boîtes MP4 (ISO BMFF) pour le remuxeur.
+
+const movieTimescale = 1000
+
+func mp4Box(typ string, parts ...[]byte) []byte {
+	size := 8
+	for _, p := range parts {
+		size += len(p)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, u32(uint32(size))...)
+	out = append(out, typ...)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, parts ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(typ, append([][]byte{header}, parts...)...)
+}
+
+var identityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func matrixBytes() []byte {
+	var b []byte
+	for _, v := range identityMatrix {
+		b = append(b, u32(v)...)
+	}
+	return b
+}
+
+// Durée d'une piste dans son propre timescale (somme des durées d'échantillons).
+func (t *remuxTrack) durations() []uint32 {
+	n := len(t.samples)
+	deltas := make([]uint32, n)
+	fallback := uint32(1024)
+	if t.streamType != streamTypeAAC {
+		fallback = 3750
+	}
+	for i := 0; i < n; i++ {
+		var d int64
+		if i+1 < n {
+			d = t.samples[i+1].dts - t.samples[i].dts
+		}
+		if d <= 0 {
+			if i > 0 {
+				d = int64(deltas[i-1])
+			} else {
+				d = int64(fallback)
+			}
+		}
+		deltas[i] = uint32(d)
+	}
+	return deltas
+}
+
+// Informations de présentation d'une piste, en timescale du film.
+type trackTiming struct {
+	deltas    []uint32
+	duration  uint64 // timescale de la piste
+	start     int64  // premier instant présenté, en 90 kHz
+	mediaTime int64  // décalage de composition du premier échantillon
+}
+
+func (t *remuxTrack) timing() trackTiming {
+	tt := trackTiming{deltas: t.durations()}
+	for _, d := range tt.deltas {
+		tt.duration += uint64(d)
+	}
+	first := t.samples[0]
+	tt.mediaTime = first.cto
+	tt.start = (first.dts + first.cto) * 90000 / int64(t.timescale)
+	return tt
+}
+
+func buildMoov(tracks []*remuxTrack) []byte {
+	timings := make([]trackTiming, len(tracks))
+	globalStart := int64(-1)
+	for i, t := range tracks {
+		timings[i] = t.timing()
+		if globalStart < 0 || timings[i].start < globalStart {
+			globalStart = timings[i].start
+		}
+	}
+
+	var traks [][]byte
+	var movieDuration uint64
+	nextID := uint32(1)
+	for i, t := range tracks {
+		tt := timings[i]
+		emptyMs := uint64((tt.start - globalStart) * movieTimescale / 90000)
+		durMs := tt.duration * movieTimescale / uint64(t.timescale)
+		movieDuration = max(movieDuration, emptyMs+durMs)
+		traks = append(traks, buildTrak(t, tt, emptyMs, durMs))
+		nextID = max(nextID, t.id+1)
+	}
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		u32(0), u32(0), // dates de création / modification
+		u32(movieTimescale), u32(uint32(movieDuration)),
+		u32(0x00010000), u16(0x0100), make([]byte, 10),
+		matrixBytes(), make([]byte, 24), u32(nextID))
+
+	return mp4Box("moov", append([][]byte{mvhd}, traks...)...)
+}
+
+func buildTrak(t *remuxTrack, tt trackTiming, emptyMs, durMs uint64) []byte {
+	isVideo := t.streamType != streamTypeAAC
+
+	volume := uint16(0x0100)
+	var width, height uint32
+	if isVideo {
+		volume = 0
+		info := t.videoInfo()
+		width, height = uint32(info.width)<<16, uint32(info.height)<<16
+	}
+	tkhd := mp4FullBox("tkhd", 0, 3,
+		u32(0), u32(0), u32(t.id), u32(0), u32(uint32(emptyMs+durMs)),
+		make([]byte, 8), u16(0), u16(0), u16(volume), u16(0),
+		matrixBytes(), u32(width), u32(height))
+
+	// Liste d'éditions : décalage de démarrage entre pistes et suppression
+	// du décalage de composition initial (B-frames).
+	var entries [][]byte
+	if emptyMs > 0 {
+		entries = append(entries, u32(uint32(emptyMs)), u32(0xFFFFFFFF), u32(0x00010000))
+	}
+	entries = append(entries, u32(uint32(durMs)), u32(uint32(tt.mediaTime)), u32(0x00010000))
+	elst := mp4FullBox("elst", 0, 0, append([][]byte{u32(uint32(len(entries) / 3))}, entries...)...)
+	edts := mp4Box("edts", elst)
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		u32(0), u32(0), u32(t.timescale), u32(uint32(tt.duration)),
+		u16(0x55C4), u16(0)) // langue "und"
+
+	handler, name, mediaHeader := "soun", "SoundHandler", mp4FullBox("smhd", 0, 0, u16(0), u16(0))
+	if isVideo {
+		handler, name, mediaHeader = "vide", "VideoHandler", mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	hdlr := mp4FullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12), append([]byte(name), 0))
+
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1)))
+
+	stbl := mp4Box("stbl", buildStbl(t, tt)...)
+	minf := mp4Box("minf", mediaHeader, dinf, stbl)
+	mdia := mp4Box("mdia", mdhd, hdlr, minf)
+	return mp4Box("trak", tkhd, edts, mdia)
+}
+
+func buildStbl(t *remuxTrack, tt trackTiming) [][]byte {
+	boxes := [][]byte{mp4FullBox("stsd", 0, 0, u32(1), buildSampleEntry(t))}
+
+	// stts : durées d'échantillons (run-length)
+	var stts []byte
+	sttsEntries := uint32(0)
+	for i := 0; i < len(tt.deltas); {
+		j := i
+		for j < len(tt.deltas) && tt.deltas[j] == tt.deltas[i] {
+			j++
+		}
+		stts = append(stts, append(u32(uint32(j-i)), u32(tt.deltas[i])...)...)
+		sttsEntries++
+		i = j
+	}
+	boxes = append(boxes, mp4FullBox("stts", 0, 0, u32(sttsEntries), stts))
+
+	// ctts : décalages de composition, seulement s'il y en a
+	hasCTO, negative := false, false
+	for _, s := range t.samples {
+		if s.cto != 0 {
+			hasCTO = true
+		}
+		if s.cto < 0 {
+			negative = true
+		}
+	}
+	if hasCTO {
+		var ctts []byte
+		entries := uint32(0)
+		for i := 0; i < len(t.samples); {
+			j := i
+			for j < len(t.samples) && t.samples[j].cto == t.samples[i].cto {
+				j++
+			}
+			ctts = append(ctts, append(u32(uint32(j-i)), u32(uint32(int32(t.samples[i].cto)))...)...)
+			entries++
+			i = j
+		}
+		version := byte(0)
+		if negative {
+			version = 1
+		}
+		boxes = append(boxes, mp4FullBox("ctts", version, 0, u32(entries), ctts))
+	}
+
+	// stss : images clés (absent = tous les échantillons sont des points de synchro)
+	var stss []byte
+	for i, s := range t.samples {
+		if s.key {
+			stss = append(stss, u32(uint32(i+1))...)
+		}
+	}
+	if len(stss)/4 != len(t.samples) {
+		boxes = append(boxes, mp4FullBox("stss", 0, 0, u32(uint32(len(stss)/4)), stss))
+	}
+
+	// stsc : nombre d'échantillons par chunk (run-length)
+	var stsc []byte
+	for i, c := range t.chunks {
+		if i == 0 || c.count != t.chunks[i-1].count {
+			stsc = append(stsc, append(u32(uint32(i+1)), append(u32(c.count), u32(1)...)...)...)
+		}
+	}
+	boxes = append(boxes, mp4FullBox("stsc", 0, 0, u32(uint32(len(stsc)/12)), stsc))
+
+	sizes := make([]byte, 0, 4*len(t.samples))
+	for _, s := range t.samples {
+		sizes = append(sizes, u32(s.size)...)
+	}
+	boxes = append(boxes, mp4FullBox("stsz", 0, 0, u32(0), u32(uint32(len(t.samples))), sizes))
+
+	offsets := make([]byte, 0, 8*len(t.chunks))
+	for _, c := range t.chunks {
+		offsets = append(offsets, u64(uint64(c.offset))...)
+	}
+	boxes = append(boxes, mp4FullBox("co64", 0, 0, u32(uint32(len(t.chunks))), offsets))
+	return boxes
+}
+
+func buildSampleEntry(t *remuxTrack) []byte {
+	if t.streamType == streamTypeAAC {
+		esds := mp4FullBox("esds", 0, 0,
+			mp4Descriptor(0x03, u16(uint16(t.id)), []byte{0},
+				mp4Descriptor(0x04, []byte{0x40, 0x15}, make([]byte, 3), u32(0), u32(0),
+					mp4Descriptor(0x05, t.asc)),
+				mp4Descriptor(0x06, []byte{0x02})))
+		return mp4Box("mp4a",
+			make([]byte, 6), u16(1), make([]byte, 8),
+			u16(uint16(t.channels)), u16(16), u16(0), u16(0), u32(t.timescale<<16),
+			esds)
+	}
+
+	typ, config := "avc1", mp4Box("avcC", buildAVCC(t))
+	if t.streamType == streamTypeH265 {
+		typ, config = "hvc1", mp4Box("hvcC", buildHVCC(t))
+	}
+	info := t.videoInfo()
+	return mp4Box(typ,
+		make([]byte, 6), u16(1), u16(0), u16(0), make([]byte, 12),
+		u16(uint16(info.width)), u16(uint16(info.height)),
+		u32(0x00480000), u32(0x00480000), u32(0), u16(1),
+		make([]byte, 32), u16(0x0018), u16(0xFFFF),
+		config)
+}
+
+func mp4Descriptor(tag byte, parts ...[]byte) []byte {
+	var payload []byte
+	for _, p := range parts {
+		payload = append(payload, p...)
+	}
+	return append([]byte{tag, byte(len(payload))}, payload...)
+}
+
+func buildAVCC(t *remuxTrack) []byte {
+	info := t.videoInfo()
+
+	b := []byte{1, t.sps[1], t.sps[2], t.sps[3], 0xFF, 0xE1}
+	b = append(b, u16(uint16(len(t.sps)))...)
+	b = append(b, t.sps...)
+	b = append(b, 1)
+	b = append(b, u16(uint16(len(t.pps)))...)
+	b = append(b, t.pps...)
+	switch t.sps[1] {
+	case 100, 110, 122, 144:
+		b = append(b, 0xFC|byte(info.chromaFormat), 0xF8|byte(info.bitDepthLuma), 0xF8|byte(info.bitDepthChroma), 0)
+	}
+	return b
+}
+
+func buildHVCC(t *remuxTrack) []byte {
+	info := t.videoInfo()
+
+	b := []byte{1}
+	b = append(b, info.profileTierLevel...)
+	b = append(b, 0xF0, 0x00, 0xFC, 0xFC|byte(info.chromaFormat), 0xF8|byte(info.bitDepthLuma), 0xF8|byte(info.bitDepthChroma))
+	b = append(b, 0, 0) // avgFrameRate
+	nesting := byte(0)
+	if info.temporalIDNesting {
+		nesting = 1
+	}
+	b = append(b, byte(info.maxSubLayers)<<3|nesting<<2|3)
+
+	var arrays [][]byte
+	for _, ps := range []struct {
+		typ byte
+		nal []byte
+	}{{32, t.vps}, {33, t.sps}, {34, t.pps}} {
+		if ps.nal == nil {
+			continue
+		}
+		a := []byte{0x80 | ps.typ}
+		a = append(a, u16(1)...)
+		a = append(a, u16(uint16(len(ps.nal)))...)
+		arrays = append(arrays, append(a, ps.nal...))
+	}
+	b = append(b, byte(len(arrays)))
+	for _, a := range arrays {
+		b = append(b, a...)
+	}
+	return b
+}
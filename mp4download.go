@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Téléchargement serveur des sources MP4 directes, façon aria2 : si l'origine
+// accepte les requêtes partielles, le fichier est découpé en morceaux
+// téléchargés sur plusieurs connexions, chacun avec ses essais et sa reprise.
+var (
+	MP4Connections       = 4
+	MP4ChunkSize   int64 = 8 << 20
+)
+
+// Morceau d'un fichier : octets [Start, End] dont Written sont déjà sur disque.
+type rangeChunk struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"` // inclus, -1 si la taille est inconnue
+	Written int64 `json:"written"`
+}
+
+func (c *rangeChunk) done() bool { return c.End >= 0 && c.Start+c.Written > c.End }
+
+// Manifeste de reprise d'un téléchargement MP4, à côté du fichier .part
+type rangeManifest struct {
+	URL          string       `json:"url"`
+	Size         int64        `json:"size"`
+	ETag         string       `json:"etag,omitempty"`
+	LastModified string       `json:"lastModified,omitempty"`
+	Chunks       []rangeChunk `json:"chunks"`
+}
+
+// Ce que l'origine annonce : taille, prise en charge des plages et validateurs.
+type mp4Origin struct {
+	size         int64 // -1 si inconnue
+	ranges       bool
+	etag         string
+	lastModified string
+}
+
+// canResume indique si le manifeste décrit le même fichier, inchangé.
+func (m *rangeManifest) canResume(url string, o mp4Origin) bool {
+	return o.ranges && len(m.Chunks) > 0 &&
+		m.URL == url && m.Size == o.size &&
+		m.ETag == o.etag && m.LastModified == o.lastModified
+}
+
+// planChunks découpe le fichier en morceaux, ou en un seul si l'origine ne
+// gère pas les plages (la reprise repart alors de zéro).
+func planChunks(o mp4Origin) []rangeChunk {
+	if !o.ranges || o.size <= 0 {
+		return []rangeChunk{{Start: 0, End: o.size - 1}}
+	}
+	size := max(MP4ChunkSize, 1)
+	var chunks []rangeChunk
+	for start := int64(0); start < o.size; start += size {
+		chunks = append(chunks, rangeChunk{Start: start, End: min(start+size, o.size) - 1})
+	}
+	return chunks
+}
+
+// Extension du fichier de sortie, d'après l'URL source
+func videoExtension(rawURL string) string {
+	ext := strings.ToLower(path.Ext(strings.SplitN(rawURL, "?", 2)[0]))
+	switch ext {
+	case ".mp4", ".m4v", ".mkv", ".webm", ".mov", ".avi":
+		return ext
+	}
+	return ".mp4"
+}
+
+// État partagé d'un téléchargement MP4 en cours
+type mp4Download struct {
+	ctx    context.Context
+	jobID  string
+	url    string
+	client *http.Client
+	gate   *pauseGate
+	origin mp4Origin
+	file   *os.File
+
+	mu           sync.Mutex // protège manifest et bytes
+	manifest     *rangeManifest
+	bytes        int64
+	lastProgress time.Time
+}
+
+var errChunkPaused = errors.New("morceau interrompu par la pause")
+
+// DownloadMP4 télécharge une source MP4 directe dans la bibliothèque.
+// Comme DownloadM3U8, l'annulation de ctx laisse un fichier partiel reprenable.
+func DownloadMP4(ctx context.Context, jobID string, targetURL string, fileName string, opts M3U8Options) error {
+	gate := jobs.gate(jobID)
+	if err := gate.Wait(ctx); err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         outboundDialContext,
+			MaxIdleConnsPerHost: MP4Connections,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: checkOutboundRedirect,
+	}
+	d := &mp4Download{ctx: ctx, jobID: jobID, url: targetURL, client: client, gate: gate}
+
+	jobs.SetStatus(jobID, "Connexion à la source...")
+	if err := d.retry("en-têtes", func() error { return d.probe() }); err != nil {
+		return err
+	}
+
+	info := opts.Media
+	if info.Title == "" {
+		info.Title = fileName
+	}
+	basePath := info.outputBase()
+	if err := os.MkdirAll(filepath.Dir(basePath), 0755); err != nil {
+		return err
+	}
+	if err := jobs.ClaimOutput(jobID, basePath); err != nil {
+		return err
+	}
+	partPath, manifestPath := partPaths(basePath)
+
+	// Reprise : même URL, même taille, mêmes validateurs, sinon on recommence
+	fresh := true
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		var m rangeManifest
+		if json.Unmarshal(data, &m) == nil && m.canResume(targetURL, d.origin) {
+			d.manifest, fresh = &m, false
+		}
+	}
+	if fresh {
+		d.manifest = &rangeManifest{
+			URL:          targetURL,
+			Size:         d.origin.size,
+			ETag:         d.origin.etag,
+			LastModified: d.origin.lastModified,
+			Chunks:       planChunks(d.origin),
+		}
+	}
+	var pending []int
+	for i := range d.manifest.Chunks {
+		c := &d.manifest.Chunks[i]
+		d.bytes += c.Written
+		if !c.done() {
+			pending = append(pending, i)
+		}
+	}
+	if !fresh {
+		log.Printf("Reprise de %s : %d/%d morceaux déjà téléchargés", fileName, len(d.manifest.Chunks)-len(pending), len(d.manifest.Chunks))
+	}
+
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fresh {
+		// Fichier pré-alloué : chaque morceau écrit directement à sa place
+		if err := f.Truncate(max(d.origin.size, 0)); err != nil {
+			return err
+		}
+	}
+	d.file = f
+	if err := d.checkpoint(manifestPath); err != nil {
+		return err
+	}
+	d.progress(true)
+
+	// Téléchargement des morceaux sur plusieurs connexions ; la première
+	// erreur définitive arrête les autres.
+	workCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	d.ctx = workCtx
+	queue := make(chan int)
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(MP4Connections, 1), max(len(pending), 1)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if err := d.fetchChunk(i); err != nil {
+					errOnce.Do(func() { firstErr = err; stop() })
+					return
+				}
+				d.progress(true)
+			}
+		}()
+	}
+
+	// Point de reprise régulier pendant le téléchargement
+	saverDone := make(chan struct{})
+	saverExited := make(chan struct{})
+	go func() {
+		defer close(saverExited)
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.checkpoint(manifestPath)
+			case <-saverDone:
+				return
+			}
+		}
+	}()
+
+dispatch:
+	for _, i := range pending {
+		select {
+		case queue <- i:
+		case <-workCtx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(saverDone)
+	<-saverExited // un point de reprise en cours écrirait le même .tmp
+	if err := d.checkpoint(manifestPath); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// Fichier complet : taille exacte, nom définitif, manifeste supprimé
+	jobs.SetStatus(jobID, "Finalisation...")
+	if err := f.Truncate(d.bytes); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	f.Close()
+	outputPath := basePath + videoExtension(targetURL)
+	if err := os.Rename(partPath, outputPath); err != nil {
+		return err
+	}
+	os.Remove(manifestPath)
+	jobs.update(jobID, func(j *Job) { j.OutputPath = outputPath })
+	return nil
+}
+
+func (d *mp4Download) newRequest() (*http.Request, error) {
+	req, err := http.NewRequestWithContext(d.ctx, "GET", d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Derrière un proxy, le nom est revérifié à chaque morceau (DNS rebinding)
+	if err := checkProxiedRequest(req); err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36")
+	return req, nil
+}
+
+// probe demande le premier octet du fichier : une réponse 206 indique que
+// l'origine accepte les plages et donne la taille totale.
+func (d *mp4Download) probe() error {
+	req, err := d.newRequest()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	o := mp4Origin{size: -1, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-0/123456
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if n, err := strconv.ParseInt(total, 10, 64); err == nil && n > 0 {
+			o.size, o.ranges = n, true
+		}
+	case http.StatusOK:
+		o.size = resp.ContentLength
+	default:
+		return fmt.Errorf("statut HTTP %d", resp.StatusCode)
+	}
+	d.origin = o
+	return nil
+}
+
+// retry exécute fn avec 5 essais. Un essai qui a fait avancer le morceau ne
+// compte pas, et une pause n'est pas un échec : on attend la reprise.
+func (d *mp4Download) retry(label string, fn func() error) error {
+	var lastErr error
+	for attempt := 0; attempt < 5; {
+		if err := d.gate.Wait(d.ctx); err != nil {
+			return err
+		}
+		before := d.written()
+		lastErr = fn()
+		switch {
+		case lastErr == nil:
+			return nil
+		case d.ctx.Err() != nil:
+			return d.ctx.Err()
+		case errors.Is(lastErr, errChunkPaused):
+			continue
+		case d.written() > before:
+			attempt = 0
+		}
+		attempt++
+		log.Printf("[!] Retry %d pour %s...", attempt, label)
+		jobs.Retrying(d.jobID, label, attempt, lastErr)
+		select {
+		case <-time.After(time.Duration(1<<(attempt-1)) * 250 * time.Millisecond):
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+	}
+	return fmt.Errorf("%s : %v", label, lastErr)
+}
+
+func (d *mp4Download) written() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytes
+}
+
+// fetchChunk télécharge un morceau, en reprenant après ses octets déjà écrits.
+func (d *mp4Download) fetchChunk(i int) error {
+	return d.retry(fmt.Sprintf("morceau %d", i), func() error {
+		d.mu.Lock()
+		c := d.manifest.Chunks[i]
+		if !d.origin.ranges && c.Written > 0 {
+			// Sans plages, impossible de reprendre au milieu : on repart du début
+			d.bytes -= c.Written
+			d.manifest.Chunks[i].Written, c.Written = 0, 0
+		}
+		d.mu.Unlock()
+
+		req, err := d.newRequest()
+		if err != nil {
+			return err
+		}
+		if d.origin.ranges {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", c.Start+c.Written, c.End))
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if d.origin.ranges && resp.StatusCode != http.StatusPartialContent {
+			return fmt.Errorf("plage ignorée par la source (statut HTTP %d)", resp.StatusCode)
+		}
+		if !d.origin.ranges && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("statut HTTP %d", resp.StatusCode)
+		}
+
+		buf := make([]byte, 256<<10)
+		for {
+			if d.gate.Paused() {
+				if d.origin.ranges {
+					return errChunkPaused
+				}
+				// Sans plages, on garde la connexion plutôt que de tout reprendre
+				if err := d.gate.Wait(d.ctx); err != nil {
+					return err
+				}
+			}
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				if c.End >= 0 {
+					n = int(min(int64(n), c.End-(c.Start+c.Written)+1))
+				}
+				if _, werr := d.file.WriteAt(buf[:n], c.Start+c.Written); werr != nil {
+					return werr
+				}
+				c.Written += int64(n)
+				d.mu.Lock()
+				d.manifest.Chunks[i].Written = c.Written
+				d.bytes += int64(n)
+				d.mu.Unlock()
+				d.progress(false)
+			}
+			if c.done() {
+				return nil
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if c.End >= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		// Taille inconnue : le morceau unique se termine avec le flux
+		d.mu.Lock()
+		d.manifest.Chunks[i].End = c.Start + c.Written - 1
+		d.mu.Unlock()
+		return nil
+	})
+}
+
+// progress publie l'avancement (au plus deux fois par seconde, sauf si force).
+func (d *mp4Download) progress(force bool) {
+	d.mu.Lock()
+	if !force && time.Since(d.lastProgress) < 500*time.Millisecond {
+		d.mu.Unlock()
+		return
+	}
+	d.lastProgress = time.Now()
+	done := 0
+	for i := range d.manifest.Chunks {
+		if d.manifest.Chunks[i].done() {
+			done++
+		}
+	}
+	total, bytes, size := len(d.manifest.Chunks), d.bytes, max(d.origin.size, 0)
+	d.mu.Unlock()
+	jobs.ProgressChunks(d.jobID, done, total, bytes, size)
+}
+
+// checkpoint sauvegarde le point de reprise. Les octets comptés dans le
+// manifeste sont écrits avant la copie : après Sync, il ne décrit jamais plus
+// que le contenu du fichier.
+func (d *mp4Download) checkpoint(manifestPath string) error {
+	d.mu.Lock()
+	m := *d.manifest
+	m.Chunks = append([]rangeChunk{}, d.manifest.Chunks...)
+	d.mu.Unlock()
+
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := manifestPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, manifestPath)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Politique des requêtes sortantes vers les URLs fournies par l'appelant
+// (check-url, téléchargements) : réglable dans la section [outbound].
+var (
+	OutboundSchemes        = []string{"http", "https"}
+	OutboundAllowPrivate   = false // autoriser loopback, réseaux privés et link-local
+	OutboundSheetHostsOnly = false // n'accepter que les hôtes vus dans une fiche servie
+)
+
+// ErrOutboundDenied signale une URL ou une adresse refusée par la politique de sortie.
+var ErrOutboundDenied = errors.New("destination refusée")
+
+// Plages refusées en plus de celles reconnues par netip (loopback, privées,
+// link-local, multicast, non spécifiées)
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "ce réseau"
+	netip.MustParsePrefix("100.64.0.0/10"),  // NAT opérateur
+	netip.MustParsePrefix("192.0.0.0/24"),   // affectations IETF
+	netip.MustParsePrefix("198.18.0.0/15"),  // bancs de test
+	netip.MustParsePrefix("240.0.0.0/4"),    // réservé, dont 255.255.255.255
+	netip.MustParsePrefix("64:ff9b:1::/48"), // NAT64 à usage local
+}
+
+// Préfixes IPv6 de transition qui portent une adresse IPv4
+var (
+	nat64Prefix      = netip.MustParsePrefix("64:ff9b::/96")
+	ipv4CompatPrefix = netip.MustParsePrefix("::/96")
+	sixToFourPrefix  = netip.MustParsePrefix("2002::/16")
+	teredoPrefix     = netip.MustParsePrefix("2001::/32")
+)
+
+// embeddedIPv4 renvoie l'adresse IPv4 portée par une adresse IPv6 de
+// transition (NAT64, IPv4-compatible, 6to4, client Teredo), vers laquelle le
+// réseau peut traduire la connexion.
+func embeddedIPv4(ip netip.Addr) (netip.Addr, bool) {
+	if !ip.Is6() {
+		return netip.Addr{}, false
+	}
+	b := ip.As16()
+	switch {
+	case nat64Prefix.Contains(ip), ipv4CompatPrefix.Contains(ip):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFourPrefix.Contains(ip):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	case teredoPrefix.Contains(ip):
+		return netip.AddrFrom4([4]byte{^b[12], ^b[13], ^b[14], ^b[15]}), true
+	}
+	return netip.Addr{}, false
+}
+
+// deniedIP indique si une adresse IP est interdite en sortie.
+func deniedIP(ip netip.Addr) bool {
+	if OutboundAllowPrivate {
+		return false
+	}
+	ip = ip.Unmap() // ::ffff:127.0.0.1 est 127.0.0.1
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range deniedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if v4, ok := embeddedIPv4(ip); ok {
+		return deniedIP(v4)
+	}
+	return false
+}
+
+// outboundControl vérifie l'adresse réellement contactée, après résolution
+// DNS : un nom qui change d'adresse entre deux résolutions (DNS rebinding)
+// ne peut pas mener vers une adresse interdite.
+func outboundControl(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w : adresse %q illisible", ErrOutboundDenied, address)
+	}
+	if deniedIP(ap.Addr()) {
+		return fmt.Errorf("%w : %s est une adresse interne", ErrOutboundDenied, ap.Addr())
+	}
+	return nil
+}
+
+var outboundDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: outboundControl}
+
+// Connexion au proxy HTTP(S)_PROXY lui-même, souvent sur le réseau local :
+// elle échappe au contrôle d'adresse. Derrière un proxy, c'est lui qui résout
+// la destination ; seule la vérification de checkOutboundURL s'applique alors.
+var proxyDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// proxyAddrs renvoie les adresses (hôte:port) des proxys de l'environnement,
+// lues une fois comme le fait http.ProxyFromEnvironment.
+var proxyAddrs = sync.OnceValue(func() map[string]bool {
+	addrs := map[string]bool{}
+	for _, env := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		raw := os.Getenv(env)
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw // même tolérance que ProxyFromEnvironment
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+		}
+		if port == "" {
+			port = "80"
+		}
+		addrs[strings.ToLower(net.JoinHostPort(u.Hostname(), port))] = true
+	}
+	return addrs
+})
+
+// outboundDialContext ouvre les connexions sortantes : contrôle d'adresse
+// pour toute destination, sauf le proxy configuré.
+func outboundDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if proxyAddrs()[strings.ToLower(addr)] {
+		return proxyDialer.DialContext(ctx, network, addr)
+	}
+	return outboundDialer.DialContext(ctx, network, addr)
+}
+
+// checkOutboundRedirect applique la politique aux redirections : le schéma
+// est revérifié, l'adresse l'est au moment de la connexion (ou ici même
+// derrière un proxy).
+func checkOutboundRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("trop de redirections")
+	}
+	if !slices.Contains(OutboundSchemes, req.URL.Scheme) {
+		return fmt.Errorf("%w : redirection vers le schéma %q", ErrOutboundDenied, req.URL.Scheme)
+	}
+	return checkProxiedRequest(req)
+}
+
+// Proxy retenu pour une requête, le même que celui des transports
+var outboundProxy = http.ProxyFromEnvironment
+
+// checkProxiedRequest revérifie la destination d'une requête qui passera par
+// le proxy de l'environnement : la connexion vise alors le proxy et le
+// contrôle du dialer ne voit plus la destination réelle. À appeler avant
+// chaque requête vers une URL lue dans une playlist ou une redirection.
+func checkProxiedRequest(req *http.Request) error {
+	if proxy, err := outboundProxy(req); err != nil || proxy == nil {
+		return nil // connexion directe : vérifiée par outboundControl
+	}
+	return checkOutboundHost(req.Context(), req.URL.Hostname())
+}
+
+// Transport partagé des requêtes sortantes ponctuelles
+var outboundTransport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           outboundDialContext,
+	MaxIdleConnsPerHost:   8,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+}
+
+// Client des requêtes courtes (playlists) : une URL qui ne répond pas ne
+// bloque jamais un handler plus de 30 s.
+var outboundHTTP = &http.Client{
+	Timeout:       30 * time.Second,
+	Transport:     outboundTransport,
+	CheckRedirect: checkOutboundRedirect,
+}
+
+// Client du proxy de fichiers : pas de délai global (le corps d'une vidéo peut
+// durer des heures), mais les en-têtes doivent arriver en 30 s
+// (ResponseHeaderTimeout) et la requête s'arrête avec celle du navigateur.
+var outboundStreamHTTP = &http.Client{
+	Transport:     outboundTransport,
+	CheckRedirect: checkOutboundRedirect,
+}
+
+// checkOutboundURL valide une URL reçue d'un appelant avant tout téléchargement :
+// schéma autorisé, hôte connu si sheet_hosts_only, et aucune adresse interne
+// parmi celles que le nom résout. La connexion revérifie l'adresse effective.
+func checkOutboundURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w : URL invalide", ErrOutboundDenied)
+	}
+	if !slices.Contains(OutboundSchemes, u.Scheme) {
+		return fmt.Errorf("%w : schéma %q non autorisé", ErrOutboundDenied, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w : URL sans hôte", ErrOutboundDenied)
+	}
+	host := u.Hostname()
+	if OutboundSheetHostsOnly && !sheetHosts.Has(host) {
+		return fmt.Errorf("%w : %s n'apparaît dans aucune fiche", ErrOutboundDenied, host)
+	}
+
+	return checkOutboundHost(ctx, host)
+}
+
+// checkOutboundHost refuse un hôte dont l'une des adresses est interne.
+func checkOutboundHost(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w : %s ne se résout pas", ErrOutboundDenied, host)
+	}
+	for _, ip := range ips {
+		if deniedIP(ip) {
+			return fmt.Errorf("%w : %s pointe vers une adresse interne (%s)", ErrOutboundDenied, host, ip.Unmap())
+		}
+	}
+	return nil
+}
+
+// Hôtes des URLs de lecture présentes dans les fiches envoyées à l'UI
+type hostSet struct {
+	mu    sync.RWMutex
+	hosts map[string]struct{}
+}
+
+var sheetHosts = &hostSet{hosts: make(map[string]struct{})}
+
+// Remember enregistre les hôtes des URLs d'une fiche.
+func (s *hostSet) Remember(urls []SheetURL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, su := range urls {
+		if u, err := url.Parse(su.URL); err == nil && u.Hostname() != "" {
+			s.hosts[strings.ToLower(u.Hostname())] = struct{}{}
+		}
+	}
+}
+
+func (s *hostSet) Has(host string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.hosts[strings.ToLower(host)]
+	return ok
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestDeniedIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		denied bool
+	}{
+		{"93.184.216.34", false},
+		{"1.1.1.1", false},
+		{"2606:4700:4700::1111", false},
+
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"255.255.255.255", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+
+		// IPv4 portée par une adresse de transition
+		{"64:ff9b::a9fe:a9fe", true},                   // NAT64 -> 169.254.169.254
+		{"64:ff9b::a00:1", true},                       // NAT64 -> 10.0.0.1
+		{"64:ff9b::5db8:d822", false},                  // NAT64 -> 93.184.216.34
+		{"64:ff9b:1::1", true},                         // NAT64 à usage local
+		{"::a00:1", true},                              // IPv4-compatible -> 10.0.0.1
+		{"::7f00:1", true},                             // IPv4-compatible -> 127.0.0.1
+		{"2002:a9fe:a9fe::1", true},                    // 6to4 -> 169.254.169.254
+		{"2002:c0a8:101::1", true},                     // 6to4 -> 192.168.1.1
+		{"2002:5db8:d822::1", false},                   // 6to4 -> 93.184.216.34
+		{"2001:0:4136:e378:8000:63bf:f5ff:fffe", true}, // Teredo, client 10.0.0.1
+	}
+	for _, tt := range tests {
+		if got := deniedIP(netip.MustParseAddr(tt.ip)); got != tt.denied {
+			t.Errorf("deniedIP(%s) = %v, attendu %v", tt.ip, got, tt.denied)
+		}
+	}
+
+	OutboundAllowPrivate = true
+	defer func() { OutboundAllowPrivate = false }()
+	if deniedIP(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("allow_private : 10.0.0.1 refusée")
+	}
+}
+
+func TestCheckOutboundURL(t *testing.T) {
+	sheetHosts.Remember([]SheetURL{{URL: "https://93.184.216.34/film.m3u8"}})
+
+	tests := []struct {
+		name           string
+		url            string
+		sheetHostsOnly bool
+		denied         bool
+	}{
+		{"adresse publique", "https://93.184.216.34/video.mp4", false, false},
+		{"loopback", "http://127.0.0.1:8080/api/jobs", false, true},
+		{"localhost", "http://localhost/", false, true},
+		{"métadonnées cloud", "http://169.254.169.254/latest/meta-data/", false, true},
+		{"IPv4 mappée", "http://[::ffff:10.0.0.1]/", false, true},
+		{"NAT64 vers link-local", "http://[64:ff9b::a9fe:a9fe]/", false, true},
+		{"6to4 vers privée", "http://[2002:c0a8:101::1]/", false, true},
+		{"schéma file", "file:///etc/passwd", false, true},
+		{"schéma gopher", "gopher://93.184.216.34/", false, true},
+		{"sans hôte", "http:///chemin", false, true},
+		{"hôte vu dans une fiche", "https://93.184.216.34/autre.mp4", true, false},
+		{"hôte absent des fiches", "https://1.1.1.1/video.mp4", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			OutboundSheetHostsOnly = tt.sheetHostsOnly
+			defer func() { OutboundSheetHostsOnly = false }()
+
+			err := checkOutboundURL(context.Background(), tt.url)
+			if tt.denied && !errors.Is(err, ErrOutboundDenied) {
+				t.Errorf("%s : %v, attendu ErrOutboundDenied", tt.url, err)
+			}
+			if !tt.denied && err != nil {
+				t.Errorf("%s : %v, attendu aucune erreur", tt.url, err)
+			}
+		})
+	}
+}
+
+// Derrière un proxy, la connexion vise le proxy : les redirections et les
+// URLs lues dans une playlist doivent être revérifiées avant la requête.
+func TestCheckProxiedRequest(t *testing.T) {
+	proxy, _ := url.Parse("http://10.0.0.2:3128")
+	prev := outboundProxy
+	t.Cleanup(func() { outboundProxy = prev })
+
+	redirect, _ := http.NewRequest("GET", "http://169.254.169.254/latest/meta-data/", nil)
+	public, _ := http.NewRequest("GET", "https://93.184.216.34/seg1.ts", nil)
+
+	outboundProxy = func(*http.Request) (*url.URL, error) { return nil, nil }
+	if err := checkOutboundRedirect(redirect, nil); err != nil {
+		t.Errorf("sans proxy : %v, attendu aucune erreur (vérifiée à la connexion)", err)
+	}
+
+	outboundProxy = func(*http.Request) (*url.URL, error) { return proxy, nil }
+	if err := checkOutboundRedirect(redirect, nil); !errors.Is(err, ErrOutboundDenied) {
+		t.Errorf("redirection via proxy : %v, attendu ErrOutboundDenied", err)
+	}
+	if err := checkProxiedRequest(public); err != nil {
+		t.Errorf("segment public via proxy : %v", err)
+	}
+}
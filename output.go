@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dossier racine de la bibliothèque (vide = ~/Downloads) et modèles de noms
+// de fichiers, sans extension. Les "/" séparent les sous-dossiers.
+// Placeholders : {title} {year} {season} {episode} {episode_name} {quality} {source}
+var (
+	LibraryRoot     = ""
+	MovieTemplate   = "Movies/{title} ({year})/{title} ({year})"
+	EpisodeTemplate = "Series/{title}/Season {season}/{title} - S{season}E{episode} - {episode_name}"
+)
+
+// Métadonnées servant à nommer un fichier téléchargé. Title est le titre du
+// film ou de la série (pas celui de l'épisode).
+type MediaInfo struct {
+	Title       string `json:"title"`
+	Year        string `json:"year,omitempty"`
+	Season      int    `json:"season,omitempty"`
+	Episode     int    `json:"episode,omitempty"`
+	EpisodeName string `json:"episodeName,omitempty"`
+	Quality     string `json:"quality,omitempty"` // ex : 1080p
+	Source      string `json:"source,omitempty"`
+}
+
+// parseMediaInfo lit les métadonnées passées en paramètres de requête :
+// mediaTitle (sinon title), year, season, episode, episodeName, source.
+func parseMediaInfo(q url.Values) MediaInfo {
+	info := MediaInfo{
+		Title:       q.Get("mediaTitle"),
+		Year:        q.Get("year"),
+		EpisodeName: q.Get("episodeName"),
+		Source:      q.Get("source"),
+	}
+	if info.Title == "" {
+		info.Title = q.Get("title")
+	}
+	info.Season, _ = strconv.Atoi(q.Get("season"))
+	info.Episode, _ = strconv.Atoi(q.Get("episode"))
+	return info
+}
+
+// yearOf extrait l'année d'une date de sortie ("2021-03-04" -> "2021").
+func yearOf(date string) string {
+	if len(date) >= 4 {
+		if _, err := strconv.Atoi(date[:4]); err == nil {
+			return date[:4]
+		}
+	}
+	return ""
+}
+
+func libraryRoot() string {
+	if LibraryRoot != "" {
+		return LibraryRoot
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Downloads")
+}
+
+var (
+	// Restes d'un placeholder vide : "()", "[]", séparateurs en double ou en bout de nom
+	emptyGroups    = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+	repeatedDashes = regexp.MustCompile(`(\s+-)+\s+-\s+`)
+	spaces         = regexp.MustCompile(`\s{2,}`)
+)
+
+// renderTemplate remplace les placeholders et nettoie chaque élément du
+// chemin (caractères interdits, restes de placeholders vides, ".."). Renvoie
+// "" si le nom du fichier lui-même est vide.
+func renderTemplate(tmpl string, info MediaInfo) string {
+	pad := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%02d", n)
+	}
+	r := strings.NewReplacer(
+		"{title}", info.Title,
+		"{year}", info.Year,
+		"{season}", pad(info.Season),
+		"{episode}", pad(info.Episode),
+		"{episode_name}", info.EpisodeName,
+		"{quality}", info.Quality,
+		"{source}", info.Source,
+	)
+
+	var parts []string
+	elements := strings.FieldsFunc(tmpl, func(c rune) bool { return c == '/' || c == '\\' })
+	for i, part := range elements {
+		// Les valeurs sont nettoyées après substitution : un "/" dans un
+		// titre ne doit pas créer de sous-dossier
+		part = sanitizeFileName(r.Replace(part))
+		part = emptyGroups.ReplaceAllString(part, "")
+		part = repeatedDashes.ReplaceAllString(part, " - ")
+		part = spaces.ReplaceAllString(part, " ")
+		part = strings.Trim(part, " .-")
+		if part == "" && i == len(elements)-1 {
+			return "" // pas de nom de fichier exploitable
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// outputBase renvoie le chemin complet, sans extension, du fichier d'un
+// téléchargement : racine de la bibliothèque + modèle film ou épisode.
+func (m MediaInfo) outputBase() string {
+	tmpl := MovieTemplate
+	if m.Season > 0 && m.Episode > 0 {
+		tmpl = EpisodeTemplate
+	}
+	rel := renderTemplate(tmpl, m)
+	if rel == "" {
+		rel = "video"
+	}
+	return filepath.Join(libraryRoot(), rel)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Playlist média résolue (après éventuel passage par une master playlist)
+type mediaPlaylist struct {
+	URL      string
+	Segments []hlsSegment
+	Variant  *HLSVariant // variante retenue, nil s'il n'y avait pas de master
+	FMP4     bool        // segments CMAF/fMP4 (EXT-X-MAP) plutôt que MPEG-TS
+}
+
+// Segment d'une playlist média, avec sa clé de chiffrement éventuelle
+type hlsSegment struct {
+	URI   string     // URL absolue
+	Seq   int64      // numéro de séquence (EXT-X-MEDIA-SEQUENCE + position)
+	Key   *hlsKey    // nil si le segment n'est pas chiffré
+	Range *byteRange // sous-partie du fichier (EXT-X-BYTERANGE), nil = fichier entier
+	Map   *hlsSegment
+}
+
+// Plage d'octets d'une ressource, traduite en en-tête Range
+type byteRange struct {
+	Length int64
+	Offset int64
+}
+
+func (r *byteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// Clé EXT-X-KEY (seul AES-128 "clear key" est supporté)
+type hlsKey struct {
+	URI string
+	IV  []byte // nil : IV dérivé du numéro de séquence
+}
+
+// segmentIV renvoie l'IV du segment : explicite, sinon le numéro de séquence
+// sur 16 octets big-endian (RFC 8216, section 5.2).
+func (s hlsSegment) segmentIV() []byte {
+	if s.Key.IV != nil {
+		return s.Key.IV
+	}
+	iv := make([]byte, 16)
+	for i := 15; i >= 8; i-- {
+		iv[i] = byte(s.Seq >> (8 * uint(15-i)))
+	}
+	return iv
+}
+
+// id identifie un segment de façon unique (l'URI seule ne suffit pas avec
+// des plages d'octets dans un même fichier).
+func (s hlsSegment) id() string {
+	if s.Range == nil {
+		return s.URI
+	}
+	return fmt.Sprintf("%s@%d:%d", s.URI, s.Range.Offset, s.Range.Length)
+}
+
+func segmentURIs(segments []hlsSegment) []string {
+	uris := make([]string, len(segments))
+	for i, s := range segments {
+		uris[i] = s.id()
+	}
+	return uris
+}
+
+// parseByteRange lit "longueur[@offset]". Sans offset, la plage suit la
+// précédente (prevEnd).
+func parseByteRange(s string, prevEnd int64) (*byteRange, error) {
+	length, offset, hasOffset := strings.Cut(s, "@")
+	n, err := strconv.ParseInt(length, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("BYTERANGE invalide : %q", s)
+	}
+	r := &byteRange{Length: n, Offset: prevEnd}
+	if hasOffset {
+		if r.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil || r.Offset < 0 {
+			return nil, fmt.Errorf("BYTERANGE invalide : %q", s)
+		}
+	}
+	return r, nil
+}
+
+// Variante d'une master playlist HLS (ligne EXT-X-STREAM-INF)
+type HLSVariant struct {
+	URI       string  `json:"uri"`
+	Bandwidth int     `json:"bandwidth"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Codecs    string  `json:"codecs,omitempty"`
+	FrameRate float64 `json:"frameRate,omitempty"`
+}
+
+func (v *HLSVariant) String() string {
+	s := fmt.Sprintf("%d kbps", v.Bandwidth/1000)
+	if v.Height > 0 {
+		s = fmt.Sprintf("%dx%d, %s", v.Width, v.Height, s)
+	}
+	return s
+}
+
+// Politiques de sélection de variante
+const (
+	VariantBest      = "best"      // meilleur débit
+	VariantWorst     = "worst"     // plus petit débit
+	VariantHeight    = "height"    // hauteur la plus proche de la cible
+	VariantBandwidth = "bandwidth" // meilleur débit sous un plafond
+)
+
+type VariantPolicy struct {
+	Mode         string `json:"mode"`
+	Height       int    `json:"height,omitempty"`
+	MaxBandwidth int    `json:"maxBandwidth,omitempty"`
+}
+
+// parseVariantPolicy lit la politique depuis les paramètres de requête
+// (variant, height, maxBandwidth).
+func parseVariantPolicy(q url.Values) (VariantPolicy, error) {
+	p := VariantPolicy{Mode: q.Get("variant")}
+	if p.Mode == "" {
+		p.Mode = VariantBest
+	}
+	if h := q.Get("height"); h != "" {
+		n, err := strconv.Atoi(h)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("hauteur invalide : %q", h)
+		}
+		p.Height = n
+	}
+	if bw := q.Get("maxBandwidth"); bw != "" {
+		n, err := strconv.Atoi(bw)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("débit max invalide : %q", bw)
+		}
+		p.MaxBandwidth = n
+	}
+
+	switch p.Mode {
+	case VariantBest, VariantWorst:
+	case VariantHeight:
+		if p.Height == 0 {
+			return p, fmt.Errorf("la politique %q demande le paramètre height", p.Mode)
+		}
+	case VariantBandwidth:
+		if p.MaxBandwidth == 0 {
+			return p, fmt.Errorf("la politique %q demande le paramètre maxBandwidth", p.Mode)
+		}
+	default:
+		return p, fmt.Errorf("politique de variante inconnue : %q", p.Mode)
+	}
+	return p, nil
+}
+
+// selectVariant choisit une variante selon la politique. Les égalités sont
+// départagées par le débit le plus élevé.
+func selectVariant(variants []HLSVariant, p VariantPolicy) *HLSVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+	sorted := append([]HLSVariant(nil), variants...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Bandwidth != sorted[j].Bandwidth {
+			return sorted[i].Bandwidth > sorted[j].Bandwidth
+		}
+		return sorted[i].Height > sorted[j].Height
+	})
+
+	switch p.Mode {
+	case VariantWorst:
+		return &sorted[len(sorted)-1]
+	case VariantHeight:
+		best, bestDiff := 0, math.MaxInt
+		for i, v := range sorted {
+			if v.Height == 0 {
+				continue
+			}
+			diff := v.Height - p.Height
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff < bestDiff {
+				best, bestDiff = i, diff
+			}
+		}
+		return &sorted[best]
+	case VariantBandwidth:
+		for i, v := range sorted {
+			if v.Bandwidth <= p.MaxBandwidth {
+				return &sorted[i]
+			}
+		}
+		// Aucune variante sous le plafond : on prend la plus légère
+		return &sorted[len(sorted)-1]
+	}
+	return &sorted[0]
+}
+
+// parseAttributeList découpe une liste d'attributs HLS (CLE=valeur,CLE="a,b").
+func parseAttributeList(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		attrs[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
+
+// parseKey interprète une ligne EXT-X-KEY. Renvoie nil pour METHOD=NONE.
+func parseKey(attrs string, base *url.URL) (*hlsKey, error) {
+	a := parseAttributeList(attrs)
+	switch a["METHOD"] {
+	case "NONE":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("chiffrement HLS non supporté : %s", a["METHOD"])
+	}
+	if a["URI"] == "" {
+		return nil, fmt.Errorf("EXT-X-KEY sans URI")
+	}
+
+	key := &hlsKey{URI: resolveRef(base, a["URI"])}
+	if iv := a["IV"]; iv != "" {
+		raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+		if err != nil || len(raw) > 16 {
+			return nil, fmt.Errorf("IV invalide : %s", iv)
+		}
+		// Complété à gauche sur 16 octets
+		key.IV = append(make([]byte, 16-len(raw)), raw...)
+	}
+	return key, nil
+}
+
+func parseStreamInf(attrs string) HLSVariant {
+	a := parseAttributeList(attrs)
+	v := HLSVariant{Codecs: a["CODECS"]}
+	v.Bandwidth, _ = strconv.Atoi(a["BANDWIDTH"])
+	if res := a["RESOLUTION"]; res != "" {
+		if w, h, ok := strings.Cut(strings.ToLower(res), "x"); ok {
+			v.Width, _ = strconv.Atoi(w)
+			v.Height, _ = strconv.Atoi(h)
+		}
+	}
+	v.FrameRate, _ = strconv.ParseFloat(a["FRAME-RATE"], 64)
+	return v
+}
+
+// resolveM3U8 suit la master playlist jusqu'à la playlist média.
+// Lecture avec un buffer illimité pour les playlists géantes.
+func resolveM3U8(ctx context.Context, uri string, policy VariantPolicy) (*mediaPlaylist, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProxiedRequest(req); err != nil { // variante lue dans la master
+		return nil, err
+	}
+	resp, err := outboundHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Une page d'erreur (403, 404...) se lirait comme une playlist vide
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("playlist : statut HTTP %d", resp.StatusCode)
+	}
+
+	var segments []hlsSegment
+	var variants []HLSVariant
+	var pending *HLSVariant // EXT-X-STREAM-INF en attente de son URI
+	var fallback string     // ancienne heuristique : première ligne en .m3u8
+	var key *hlsKey         // clé courante, s'applique jusqu'au prochain EXT-X-KEY
+	var initMap *hlsSegment // section d'initialisation courante (EXT-X-MAP)
+	var nextRange *byteRange
+	rangeEnds := make(map[string]int64) // fin de la dernière plage lue, par URI
+	var seq int64
+	baseURL, _ := url.Parse(uri)
+
+	// Utilisation de bufio.Reader au lieu de Scanner pour éviter la limite de ligne
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			v := parseStreamInf(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			pending = &v
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			k, kerr := parseKey(strings.TrimPrefix(line, "#EXT-X-KEY:"), baseURL)
+			if kerr != nil {
+				return nil, kerr
+			}
+			key = k
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			a := parseAttributeList(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			if a["URI"] == "" {
+				return nil, fmt.Errorf("EXT-X-MAP sans URI")
+			}
+			initMap = &hlsSegment{URI: resolveRef(baseURL, a["URI"]), Seq: seq, Key: key}
+			if br := a["BYTERANGE"]; br != "" {
+				r, rerr := parseByteRange(br, 0)
+				if rerr != nil {
+					return nil, rerr
+				}
+				initMap.Range = r
+			}
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			// Offset -1 : complété avec la fin de la plage précédente du segment qui suit
+			r, rerr := parseByteRange(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), -1)
+			if rerr != nil {
+				return nil, rerr
+			}
+			nextRange = r
+		case line == "" || strings.HasPrefix(line, "#"):
+		case pending != nil:
+			pending.URI = resolveRef(baseURL, line)
+			variants = append(variants, *pending)
+			pending = nil
+		case strings.Contains(line, ".m3u8"):
+			if fallback == "" {
+				fallback = resolveRef(baseURL, line)
+			}
+		default:
+			seg := hlsSegment{URI: resolveRef(baseURL, line), Seq: seq, Key: key, Map: initMap}
+			if nextRange != nil {
+				// Sans offset explicite, la plage suit la précédente du même fichier
+				if nextRange.Offset < 0 {
+					nextRange.Offset = rangeEnds[seg.URI]
+				}
+				rangeEnds[seg.URI] = nextRange.Offset + nextRange.Length
+				seg.Range, nextRange = nextRange, nil
+			}
+			segments = append(segments, seg)
+			seq++
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	if v := selectVariant(variants, policy); v != nil {
+		playlist, err := resolveM3U8(ctx, v.URI, policy)
+		if err != nil {
+			return nil, err
+		}
+		playlist.Variant = v
+		return playlist, nil
+	}
+	if fallback != "" && len(segments) == 0 {
+		return resolveM3U8(ctx, fallback, policy)
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("aucune donnée trouvée")
+	}
+	return &mediaPlaylist{URL: uri, Segments: segments, FMP4: initMap != nil}, nil
+}
+
+// resolveRef résout une URI de playlist (relative ou absolue, avec query string).
+func resolveRef(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || base == nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// allowLoopback autorise les requêtes sortantes vers le serveur de test local.
+func allowLoopback(t *testing.T) {
+	t.Helper()
+	prev := OutboundAllowPrivate
+	OutboundAllowPrivate = true
+	t.Cleanup(func() { OutboundAllowPrivate = prev })
+}
+
+func TestResolveM3U8(t *testing.T) {
+	allowLoopback(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:5\n#EXTINF:4,\ns0.ts\n#EXTINF:4,\ns1.ts\n#EXT-X-ENDLIST\n"))
+		case "/interdit.m3u8":
+			http.Error(w, "<html>Forbidden</html>", 403)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	// Sans keep-alive, aucune connexion n'est ouverte en arrière-plan par le
+	// transport partagé après la fin du test (et de allowLoopback)
+	srv.Config.SetKeepAlivesEnabled(false)
+	defer srv.Close()
+
+	pl, err := resolveM3U8(context.Background(), srv.URL+"/ok.m3u8", VariantPolicy{Mode: VariantBest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl.Segments) != 2 || pl.Segments[0].URI != srv.URL+"/s0.ts" || pl.Segments[1].Seq != 6 {
+		t.Errorf("segments inattendus : %+v", pl.Segments)
+	}
+
+	// Une page d'erreur n'est pas une playlist vide
+	for _, path := range []string{"/interdit.m3u8", "/absente.m3u8"} {
+		if _, err := resolveM3U8(context.Background(), srv.URL+path, VariantPolicy{Mode: VariantBest}); err == nil {
+			t.Errorf("%s : aucune erreur, attendu un échec sur le statut HTTP", path)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PurstreamAPI regroupe tous les appels à l'API Purstream. Les handlers
+// passent par la variable api, remplaçable par une fausse implémentation.
+type PurstreamAPI interface {
+	Search(ctx context.Context, query string) ([]Media, error)
+	LastReleases(ctx context.Context, limit int) ([]Media, error)
+	Franchise(ctx context.Context, franchiseID string) ([]Media, error)
+	Catalog(ctx context.Context, kind string, page int) ([]Media, error)
+	Sheet(ctx context.Context, mediaID string) (SheetResponse, error)
+	Season(ctx context.Context, mediaID string, season int) ([]Episode, error)
+	Stream(ctx context.Context, mediaID string) (StreamResponse, error)
+}
+
+var api PurstreamAPI = NewPurstreamClient(BaseURL)
+
+// ErrNotFound est renvoyée (via APIError) quand l'API répond 404.
+var ErrNotFound = errors.New("ressource introuvable")
+
+// ErrNoBaseURL est renvoyée tant que la découverte n'a validé aucune adresse.
+var ErrNoBaseURL = errors.New("adresse de l'API inconnue")
+
+// APIError décrit un appel à l'API qui a échoué : réseau, statut HTTP
+// inattendu ou JSON illisible.
+type APIError struct {
+	Endpoint string
+	Status   int // 0 si la requête n'a pas abouti
+	Err      error
+}
+
+func (e *APIError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("API %s : statut HTTP %d", e.Endpoint, e.Status)
+	}
+	return fmt.Sprintf("API %s : %v", e.Endpoint, e.Err)
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// PurstreamClient est le client HTTP de l'API : transport, délai et
+// en-têtes partagés par toutes les requêtes.
+type PurstreamClient struct {
+	http      *http.Client
+	baseURL   func() string // l'adresse de l'API change, elle est relue à chaque appel
+	userAgent string
+}
+
+func NewPurstreamClient(baseURL func() string) *PurstreamClient {
+	return &PurstreamClient{
+		http: &http.Client{
+			Timeout: 15 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 8,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+		baseURL:   baseURL,
+		userAgent: "Mozilla/5.0",
+	}
+}
+
+// get appelle un endpoint de l'API et décode sa réponse JSON dans out.
+func (c *PurstreamClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	base := c.baseURL()
+	if base == "" {
+		return &APIError{Endpoint: path, Err: ErrNoBaseURL}
+	}
+	remote := base + path
+	if len(query) > 0 {
+		remote += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", remote, nil)
+	if err != nil {
+		return &APIError{Endpoint: path, Err: err}
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &APIError{Endpoint: path, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &APIError{Endpoint: path, Status: resp.StatusCode, Err: ErrNotFound}
+	case resp.StatusCode != http.StatusOK:
+		return &APIError{Endpoint: path, Status: resp.StatusCode, Err: fmt.Errorf("statut HTTP %d", resp.StatusCode)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &APIError{Endpoint: path, Err: fmt.Errorf("JSON illisible : %v", err)}
+	}
+	return nil
+}
+
+// toMedia convertit un film / une série de l'API vers le type envoyé à l'UI.
+func toMedia(m PurestreamMovie) Media {
+	return Media{
+		Title:    m.Title,
+		ID:       m.ID,
+		ThumbURL: m.LargePosterPath,
+		Kind:     m.Type,
+		Runtime:  fmt.Sprintf("%d min", m.Runtime), // Conversion int -> string
+		Updated:  m.UpdatedAt,
+	}
+}
+
+func toMediaList(items []PurestreamMovie) []Media {
+	results := make([]Media, 0, len(items))
+	for _, m := range items {
+		results = append(results, toMedia(m))
+	}
+	return results
+}
+
+func (c *PurstreamClient) Search(ctx context.Context, query string) ([]Media, error) {
+	var data PurestreamResponse
+	if err := c.get(ctx, "/api/v1/search-bar/search/"+url.PathEscape(query), nil, &data); err != nil {
+		return nil, err
+	}
+	return toMediaList(data.Data.Items.Movies.Items), nil
+}
+
+// LastReleases renvoie les derniers ajouts (l'API renvoie un tableau d'items directement dans Data).
+func (c *PurstreamClient) LastReleases(ctx context.Context, limit int) ([]Media, error) {
+	var data struct {
+		Data struct {
+			Items []PurestreamMovie `json:"items"`
+		} `json:"data"`
+	}
+	if err := c.get(ctx, "/api/v1/last-released-movies/"+strconv.Itoa(limit), nil, &data); err != nil {
+		return nil, err
+	}
+	return toMediaList(data.Data.Items), nil
+}
+
+func (c *PurstreamClient) Franchise(ctx context.Context, franchiseID string) ([]Media, error) {
+	var data FranchiseAPIResponse
+	if err := c.get(ctx, "/api/v1/franchise/"+url.PathEscape(franchiseID), nil, &data); err != nil {
+		return nil, err
+	}
+	results := []Media{}
+	for _, m := range data.Data.Items.Franchise.Movies.Items {
+		results = append(results, toMedia(PurestreamMovie{
+			ID:              m.ID,
+			Title:           m.Title,
+			Type:            m.Type,
+			Runtime:         m.Runtime,
+			UpdatedAt:       m.UpdatedAt,
+			LargePosterPath: m.LargePosterPath,
+		}))
+	}
+	return results, nil
+}
+
+// Catalog renvoie une page (100 éléments) du catalogue d'un type : movie, tv ou anime.
+func (c *PurstreamClient) Catalog(ctx context.Context, kind string, page int) ([]Media, error) {
+	var data struct {
+		Data struct {
+			Items struct {
+				Data []PurestreamMovie `json:"data"` // Le tableau est ici
+			} `json:"items"`
+		} `json:"data"`
+	}
+	query := url.Values{
+		"sortBy":  {"best-rated"},
+		"types":   {kind},
+		"perPage": {"100"},
+		"page":    {strconv.Itoa(page)},
+	}
+	if err := c.get(ctx, "/api/v1/catalog/movies", query, &data); err != nil {
+		return nil, err
+	}
+	return toMediaList(data.Data.Items.Data), nil
+}
+
+// Sheet renvoie la fiche d'un média (titre, saisons et toutes les URLs de lecture).
+func (c *PurstreamClient) Sheet(ctx context.Context, mediaID string) (SheetResponse, error) {
+	var sheet SheetResponse
+	err := c.get(ctx, "/api/v1/media/"+url.PathEscape(mediaID)+"/sheet", nil, &sheet)
+	return sheet, err
+}
+
+// Season renvoie les épisodes (numéro et nom) d'une saison.
+func (c *PurstreamClient) Season(ctx context.Context, mediaID string, season int) ([]Episode, error) {
+	var data SeasonDetailResponse
+	path := fmt.Sprintf("/api/v1/media/%s/season/%d", url.PathEscape(mediaID), season)
+	if err := c.get(ctx, path, nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Data.Items.Episodes, nil
+}
+
+// Stream renvoie les sources de lecture directes d'un média.
+func (c *PurstreamClient) Stream(ctx context.Context, mediaID string) (StreamResponse, error) {
+	var data StreamResponse
+	err := c.get(ctx, "/api/v1/media/"+url.PathEscape(mediaID)+"/stream", nil, &data)
+	return data, err
+}
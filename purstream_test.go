@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// fakeAPI est une fausse API Purstream en mémoire pour tester les handlers
+// sans réseau. Un média absent de sheets répond ErrNotFound, comme un 404.
+type fakeAPI struct {
+	results []Media
+	sheets  map[string]SheetResponse
+	seasons map[string][]Episode // clé : "<id>/<saison>"
+	err     error                // si non nil, renvoyée par tous les appels
+}
+
+func (f *fakeAPI) Search(ctx context.Context, query string) ([]Media, error) {
+	return f.results, f.err
+}
+
+func (f *fakeAPI) LastReleases(ctx context.Context, limit int) ([]Media, error) {
+	return f.results, f.err
+}
+
+func (f *fakeAPI) Franchise(ctx context.Context, franchiseID string) ([]Media, error) {
+	return f.results, f.err
+}
+
+func (f *fakeAPI) Catalog(ctx context.Context, kind string, page int) ([]Media, error) {
+	return f.results, f.err
+}
+
+func (f *fakeAPI) Sheet(ctx context.Context, mediaID string) (SheetResponse, error) {
+	if f.err != nil {
+		return SheetResponse{}, f.err
+	}
+	sheet, ok := f.sheets[mediaID]
+	if !ok {
+		return SheetResponse{}, &APIError{Endpoint: "/api/v1/media/" + mediaID + "/sheet", Status: 404, Err: ErrNotFound}
+	}
+	return sheet, nil
+}
+
+func (f *fakeAPI) Season(ctx context.Context, mediaID string, season int) ([]Episode, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	episodes, ok := f.seasons[mediaID+"/"+strconv.Itoa(season)]
+	if !ok {
+		return nil, &APIError{Endpoint: "/api/v1/media/" + mediaID + "/season", Status: 404, Err: ErrNotFound}
+	}
+	return episodes, nil
+}
+
+func (f *fakeAPI) Stream(ctx context.Context, mediaID string) (StreamResponse, error) {
+	return StreamResponse{}, f.err
+}
+
+// useFakeAPI remplace l'API le temps d'un test.
+func useFakeAPI(t *testing.T, f *fakeAPI) {
+	t.Helper()
+	prev := api
+	api = f
+	t.Cleanup(func() { api = prev })
+}
+
+// sheetOf construit une fiche avec ses URLs de lecture.
+func sheetOf(id int, title string, urls ...string) SheetResponse {
+	var s SheetResponse
+	s.Data.Items.ID = id
+	s.Data.Items.Title = title
+	for _, u := range urls {
+		s.Data.Items.Urls = append(s.Data.Items.Urls, SheetURL{URL: u, Name: "source"})
+	}
+	return s
+}
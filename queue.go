@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Nombre maximum de téléchargements simultanés (les autres attendent leur tour)
+var MaxConcurrentDownloads = 3
+
+// Types de téléchargement gérés par la file
+const (
+	KindM3U8 = "m3u8"
+	KindMP4  = "mp4"
+)
+
+// Entrée de la file : tout ce qu'il faut pour (re)lancer un job après un redémarrage.
+type queueEntry struct {
+	JobID   string      `json:"jobId"`
+	Title   string      `json:"title"`
+	URL     string      `json:"url"`
+	Kind    string      `json:"kind,omitempty"` // KindM3U8 (défaut, files sauvegardées avant les MP4) ou KindMP4
+	Options M3U8Options `json:"options"`
+}
+
+// downloadKind renvoie le type de téléchargement d'une source (voir sourceFormat).
+func downloadKind(format string) string {
+	if format == "mp4" {
+		return KindMP4
+	}
+	return KindM3U8
+}
+
+// File de téléchargements persistante avec limite de concurrence globale.
+// Les jobs en attente sont lancés dans l'ordre de la file ; l'ordre peut être
+// modifié (priorité). La file est sauvegardée à chaque changement pour
+// survivre à un redémarrage : les jobs interrompus reprennent via leur manifeste.
+type DownloadQueue struct {
+	mu            sync.Mutex
+	path          string
+	maxConcurrent int
+	waiting       []queueEntry
+	running       map[string]queueEntry
+}
+
+var downloadQueue *DownloadQueue
+
+func defaultQueuePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir, _ = os.UserHomeDir()
+	}
+	return filepath.Join(dir, "Xaladownloader", "queue.json")
+}
+
+func NewDownloadQueue(path string, maxConcurrent int) *DownloadQueue {
+	return &DownloadQueue{
+		path:          path,
+		maxConcurrent: max(maxConcurrent, 1),
+		running:       make(map[string]queueEntry),
+	}
+}
+
+// Load recharge la file sauvegardée. Les jobs qui tournaient au moment de
+// l'arrêt sont remis en tête de file.
+func (q *DownloadQueue) Load() error {
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved struct {
+		Running []queueEntry `json:"running"`
+		Waiting []queueEntry `json:"waiting"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("file de téléchargement illisible (%s) : %v", q.path, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range append(saved.Running, saved.Waiting...) {
+		jobs.Restore(e.JobID, e.Title, e.URL)
+		q.waiting = append(q.waiting, e)
+	}
+	if len(q.waiting) > 0 {
+		log.Printf("File de téléchargement restaurée : %d job(s)", len(q.waiting))
+	}
+	return nil
+}
+
+// save écrit la file sur disque (appelé avec q.mu verrouillé). Les jobs
+// annulés pendant leur attente n'y figurent plus.
+func (q *DownloadQueue) save() {
+	saved := struct {
+		Running []queueEntry `json:"running"`
+		Waiting []queueEntry `json:"waiting"`
+	}{Running: []queueEntry{}, Waiting: []queueEntry{}}
+	for _, e := range q.running {
+		saved.Running = append(saved.Running, e)
+	}
+	for _, e := range q.waiting {
+		if j, ok := jobs.Get(e.JobID); ok && !j.State.Terminal() {
+			saved.Waiting = append(saved.Waiting, e)
+		}
+	}
+
+	data, _ := json.MarshalIndent(saved, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		log.Printf("Sauvegarde de la file impossible : %v", err)
+		return
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Sauvegarde de la file impossible : %v", err)
+		return
+	}
+	os.Rename(tmp, q.path)
+}
+
+// Add place un job en fin de file.
+func (q *DownloadQueue) Add(e queueEntry) {
+	q.mu.Lock()
+	q.waiting = append(q.waiting, e)
+	q.save()
+	q.mu.Unlock()
+	q.schedule()
+}
+
+// Move déplace un job en attente à la position donnée (0 = prochain lancé).
+func (q *DownloadQueue) Move(jobID string, position int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	from := -1
+	for i, e := range q.waiting {
+		if e.JobID == jobID {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return fmt.Errorf("job %s absent de la file d'attente", jobID)
+	}
+	e := q.waiting[from]
+	q.waiting = append(q.waiting[:from], q.waiting[from+1:]...)
+	position = min(max(position, 0), len(q.waiting))
+	q.waiting = append(q.waiting[:position], append([]queueEntry{e}, q.waiting[position:]...)...)
+	q.save()
+	return nil
+}
+
+// Remove retire un job de la file d'attente (annulé avant son lancement).
+func (q *DownloadQueue) Remove(jobID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.waiting {
+		if e.JobID == jobID {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.save()
+			return
+		}
+	}
+}
+
+// SetMaxConcurrent change la limite à chaud ; les jobs en trop ne sont pas
+// interrompus, la file attend simplement qu'ils se terminent.
+func (q *DownloadQueue) SetMaxConcurrent(n int) error {
+	if n < 1 {
+		return fmt.Errorf("la limite doit être d'au moins 1")
+	}
+	q.mu.Lock()
+	q.maxConcurrent = n
+	q.mu.Unlock()
+	q.schedule()
+	return nil
+}
+
+// schedule lance autant de jobs en attente que la limite le permet.
+func (q *DownloadQueue) schedule() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.running) < q.maxConcurrent && len(q.waiting) > 0 {
+		e := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		// Annulé pendant l'attente : on l'oublie
+		if j, ok := jobs.Get(e.JobID); !ok || j.State != JobQueued {
+			continue
+		}
+		q.running[e.JobID] = e
+		go q.run(e)
+	}
+	q.save()
+}
+
+func (q *DownloadQueue) run(e queueEntry) {
+	runDownloadJob(e)
+
+	q.mu.Lock()
+	delete(q.running, e.JobID)
+	q.mu.Unlock()
+	q.schedule()
+}
+
+// Vue de la file pour l'API
+type QueueSnapshot struct {
+	MaxConcurrent int   `json:"maxConcurrent"`
+	Running       []Job `json:"running"`
+	Waiting       []Job `json:"waiting"`
+}
+
+func (q *DownloadQueue) Snapshot() QueueSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snap := QueueSnapshot{MaxConcurrent: q.maxConcurrent, Running: []Job{}, Waiting: []Job{}}
+	for id := range q.running {
+		if j, ok := jobs.Get(id); ok {
+			snap.Running = append(snap.Running, j)
+		}
+	}
+	for _, e := range q.waiting {
+		if j, ok := jobs.Get(e.JobID); ok && j.State == JobQueued {
+			snap.Waiting = append(snap.Waiting, j)
+		}
+	}
+	return snap
+}
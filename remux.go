@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Remuxage MPEG-TS -> MP4 en Go pur (pas besoin de ffmpeg).
+// Les échantillons sont écrits au fil de l'eau dans le mdat, seules les tables
+// (tailles, timestamps, offsets) restent en mémoire, puis le moov est ajouté à la fin.
+
+const tsPacketSize = 188
+
+// Types de flux de la PMT qu'on sait remuxer
+const (
+	streamTypeAAC  = 0x0F
+	streamTypeH264 = 0x1B
+	streamTypeH265 = 0x24
+)
+
+// Formats de sortie pour les téléchargements M3U8
+const (
+	ContainerMP4 = "mp4"
+	ContainerTS  = "ts"
+)
+
+type mp4Sample struct {
+	size uint32
+	dts  int64
+	cto  int64 // pts - dts (composition offset)
+	key  bool
+}
+
+type mp4Chunk struct {
+	offset int64
+	count  uint32
+}
+
+type remuxTrack struct {
+	id         uint32
+	streamType byte
+	timescale  uint32
+	samples    []mp4Sample
+	chunks     []mp4Chunk
+
+	pes     []byte // PES en cours d'assemblage
+	lastDTS int64
+	hasDTS  bool
+	started bool // vidéo : on attend la première image clé
+
+	// Vidéo
+	vps, sps, pps []byte
+
+	// Audio
+	asc      []byte // AudioSpecificConfig
+	channels int
+}
+
+type tsRemuxer struct {
+	w         *bufio.Writer
+	offset    int64
+	pmtPID    int
+	tracks    map[int]*remuxTrack
+	video     *remuxTrack
+	audio     *remuxTrack
+	lastTrack *remuxTrack // pour regrouper les échantillons consécutifs en chunks
+}
+
+// RemuxTSToMP4 convertit un fichier MPEG-TS (concaténation de segments HLS)
+// en un MP4 avec index moov.
+func RemuxTSToMP4(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	r := &tsRemuxer{w: bufio.NewWriterSize(out, 1<<20), tracks: make(map[int]*remuxTrack)}
+
+	if err := r.write(mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2avc1mp41"))); err != nil {
+		return err
+	}
+	// mdat avec taille 64 bits, complétée une fois les données écrites
+	mdatStart := r.offset
+	if err := r.write(append(u32(1), append([]byte("mdat"), u64(0)...)...)); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(in, 1<<20)
+	pkt := make([]byte, tsPacketSize)
+	for {
+		if _, err := io.ReadFull(reader, pkt[:1]); err != nil {
+			break
+		}
+		// Resynchronisation sur l'octet 0x47 si le flux est décalé
+		if pkt[0] != 0x47 {
+			continue
+		}
+		if _, err := io.ReadFull(reader, pkt[1:]); err != nil {
+			break
+		}
+		if err := r.handlePacket(pkt); err != nil {
+			return err
+		}
+	}
+	for _, t := range r.tracks {
+		if err := r.flushPES(t); err != nil {
+			return err
+		}
+	}
+
+	var tracks []*remuxTrack
+	for _, t := range []*remuxTrack{r.video, r.audio} {
+		if t != nil && len(t.samples) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	if len(tracks) == 0 {
+		return fmt.Errorf("aucun flux audio/vidéo exploitable dans %s", src)
+	}
+	if r.video != nil && len(r.video.samples) > 0 {
+		if err := r.video.checkParamSets(); err != nil {
+			return fmt.Errorf("%s : %w", src, err)
+		}
+	}
+
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := out.WriteAt(u64(uint64(r.offset-mdatStart)), mdatStart+8); err != nil {
+		return err
+	}
+	if _, err := out.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := out.Write(buildMoov(tracks)); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+func (r *tsRemuxer) write(b []byte) error {
+	n, err := r.w.Write(b)
+	r.offset += int64(n)
+	return err
+}
+
+func (r *tsRemuxer) handlePacket(pkt []byte) error {
+	pusi := pkt[1]&0x40 != 0
+	pid := int(pkt[1]&0x1F)<<8 | int(pkt[2])
+	afc := (pkt[3] >> 4) & 0x3
+
+	payload := pkt[4:]
+	switch afc {
+	case 0, 2: // pas de charge utile
+		return nil
+	case 3:
+		l := int(payload[0])
+		if 1+l >= len(payload) {
+			return nil
+		}
+		payload = payload[1+l:]
+	}
+
+	switch {
+	case pid == 0:
+		if pusi {
+			r.parsePAT(payload)
+		}
+	case pid == r.pmtPID && r.pmtPID != 0:
+		if pusi {
+			r.parsePMT(payload)
+		}
+	default:
+		t := r.tracks[pid]
+		if t == nil {
+			return nil
+		}
+		if pusi {
+			if err := r.flushPES(t); err != nil {
+				return err
+			}
+			t.pes = append(t.pes, payload...)
+		} else if len(t.pes) > 0 {
+			t.pes = append(t.pes, payload...)
+		}
+	}
+	return nil
+}
+
+// psiSection renvoie le contenu d'une section PSI (sans le CRC).
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	ptr := int(payload[0])
+	if 1+ptr+3 > len(payload) {
+		return nil
+	}
+	sec := payload[1+ptr:]
+	length := int(sec[1]&0x0F)<<8 | int(sec[2])
+	if 3+length > len(sec) || length < 4 {
+		return nil
+	}
+	return sec[:3+length-4]
+}
+
+func (r *tsRemuxer) parsePAT(payload []byte) {
+	sec := psiSection(payload)
+	if len(sec) < 8 || sec[0] != 0x00 {
+		return
+	}
+	for i := 8; i+4 <= len(sec); i += 4 {
+		program := int(sec[i])<<8 | int(sec[i+1])
+		if program != 0 {
+			r.pmtPID = int(sec[i+2]&0x1F)<<8 | int(sec[i+3])
+			return
+		}
+	}
+}
+
+func (r *tsRemuxer) parsePMT(payload []byte) {
+	sec := psiSection(payload)
+	if len(sec) < 12 || sec[0] != 0x02 {
+		return
+	}
+	infoLen := int(sec[10]&0x0F)<<8 | int(sec[11])
+	for i := 12 + infoLen; i+5 <= len(sec); {
+		streamType := sec[i]
+		pid := int(sec[i+1]&0x1F)<<8 | int(sec[i+2])
+		esInfoLen := int(sec[i+3]&0x0F)<<8 | int(sec[i+4])
+		i += 5 + esInfoLen
+
+		if r.tracks[pid] != nil {
+			continue
+		}
+		// On garde le premier flux vidéo et le premier flux audio
+		switch streamType {
+		case streamTypeH264, streamTypeH265:
+			if r.video == nil {
+				r.video = &remuxTrack{streamType: streamType, timescale: 90000}
+				r.tracks[pid] = r.video
+			}
+		case streamTypeAAC:
+			if r.audio == nil {
+				r.audio = &remuxTrack{streamType: streamType}
+				r.tracks[pid] = r.audio
+			}
+		}
+	}
+	id := uint32(1)
+	for _, t := range []*remuxTrack{r.video, r.audio} {
+		if t != nil {
+			t.id = id
+			id++
+		}
+	}
+}
+
+// readPESTimestamp décode un PTS/DTS sur 33 bits.
+func readPESTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// unwrapTimestamp corrige le rebouclage des timestamps 33 bits en choisissant
+// la valeur la plus proche de la référence.
+func unwrapTimestamp(ts, ref int64) int64 {
+	k := (ref - ts + 1<<32) >> 33
+	return ts + k<<33
+}
+
+func (r *tsRemuxer) flushPES(t *remuxTrack) error {
+	data := t.pes
+	defer func() { t.pes = data[:0] }()
+
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return nil
+	}
+	if pesLen := int(data[4])<<8 | int(data[5]); pesLen > 0 && 6+pesLen < len(data) {
+		data = data[:6+pesLen]
+	}
+	flags := data[7]
+	hdrLen := int(data[8])
+	if 9+hdrLen > len(data) {
+		return nil
+	}
+
+	pts, dts := int64(-1), int64(-1)
+	if flags&0x80 != 0 && hdrLen >= 5 {
+		pts = readPESTimestamp(data[9:])
+		dts = pts
+	}
+	if flags&0xC0 == 0xC0 && hdrLen >= 10 {
+		dts = readPESTimestamp(data[14:])
+	}
+	payload := data[9+hdrLen:]
+
+	if t.streamType == streamTypeAAC {
+		return r.writeAudio(t, payload, pts)
+	}
+	return r.writeVideo(t, payload, pts, dts)
+}
+
+// startSample ouvre un nouveau chunk si l'échantillon précédent venait d'une autre piste.
+func (r *tsRemuxer) startSample(t *remuxTrack) {
+	if r.lastTrack != t || len(t.chunks) == 0 {
+		t.chunks = append(t.chunks, mp4Chunk{offset: r.offset})
+		r.lastTrack = t
+	}
+	t.chunks[len(t.chunks)-1].count++
+}
+
+func (r *tsRemuxer) writeVideo(t *remuxTrack, payload []byte, pts, dts int64) error {
+	hevc := t.streamType == streamTypeH265
+
+	if pts < 0 {
+		if !t.hasDTS {
+			return nil
+		}
+		// Pas de timestamp : on extrapole à partir de la durée précédente
+		delta := int64(3750)
+		if n := len(t.samples); n > 1 {
+			delta = t.samples[n-1].dts - t.samples[n-2].dts
+		}
+		dts = t.lastDTS + delta
+		pts = dts
+	} else {
+		if t.hasDTS {
+			dts = unwrapTimestamp(dts, t.lastDTS)
+		}
+		pts = unwrapTimestamp(pts, dts)
+	}
+
+	var nals [][]byte
+	key := false
+	for _, nal := range splitAnnexB(payload) {
+		if hevc {
+			switch typ := nal[0] >> 1 & 0x3F; {
+			case typ == 32:
+				t.vps = storeParamSet(t.vps, nal)
+			case typ == 33:
+				t.sps = storeParamSet(t.sps, nal)
+			case typ == 34:
+				t.pps = storeParamSet(t.pps, nal)
+			case typ == 35: // AUD
+			default:
+				if typ >= 16 && typ <= 21 {
+					key = true
+				}
+				nals = append(nals, nal)
+			}
+		} else {
+			switch nal[0] & 0x1F {
+			case 7:
+				t.sps = storeParamSet(t.sps, nal)
+			case 8:
+				t.pps = storeParamSet(t.pps, nal)
+			case 9: // AUD
+			case 5:
+				key = true
+				nals = append(nals, nal)
+			default:
+				nals = append(nals, nal)
+			}
+		}
+	}
+
+	// Les images précédant la première image clé ne sont pas décodables
+	if len(nals) == 0 || (!t.started && (!key || t.sps == nil || t.pps == nil)) {
+		return nil
+	}
+	t.started = true
+
+	r.startSample(t)
+	size := 0
+	for _, nal := range nals {
+		if err := r.write(u32(uint32(len(nal)))); err != nil {
+			return err
+		}
+		if err := r.write(nal); err != nil {
+			return err
+		}
+		size += 4 + len(nal)
+	}
+	t.samples = append(t.samples, mp4Sample{size: uint32(size), dts: dts, cto: pts - dts, key: key})
+	t.lastDTS, t.hasDTS = dts, true
+	return nil
+}
+
+// storeParamSet garde le premier jeu de paramètres rencontré (copie, car le
+// buffer PES est réutilisé).
+func storeParamSet(current, nal []byte) []byte {
+	if current != nil {
+		return current
+	}
+	return append([]byte(nil), nal...)
+}
+
+var adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+func (r *tsRemuxer) writeAudio(t *remuxTrack, payload []byte, pts int64) error {
+	for len(payload) >= 7 {
+		if payload[0] != 0xFF || payload[1]&0xF0 != 0xF0 {
+			return nil
+		}
+		protectionAbsent := payload[1] & 0x01
+		profile := payload[2] >> 6
+		sfi := payload[2] >> 2 & 0x0F
+		channels := (payload[2]&0x01)<<2 | payload[3]>>6
+		frameLen := int(payload[3]&0x03)<<11 | int(payload[4])<<3 | int(payload[5])>>5
+		hdrLen := 7
+		if protectionAbsent == 0 {
+			hdrLen = 9
+		}
+		if frameLen <= hdrLen || frameLen > len(payload) || int(sfi) >= len(adtsSampleRates) {
+			return nil
+		}
+
+		if t.asc == nil {
+			t.timescale = uint32(adtsSampleRates[sfi])
+			t.channels = int(channels)
+			t.asc = []byte{(profile+1)<<3 | sfi>>1, (sfi&1)<<7 | channels<<3}
+		}
+
+		// Le PTS du PES sert de point d'ancrage, les trames suivantes
+		// s'enchaînent de 1024 échantillons.
+		if pts >= 0 {
+			if t.hasDTS {
+				pts = unwrapTimestamp(pts, t.lastDTS*90000/int64(t.timescale))
+			}
+			dts := pts * int64(t.timescale) / 90000
+			if !t.hasDTS || abs64(dts-(t.lastDTS+1024)) > 2048 {
+				t.lastDTS, t.hasDTS = dts-1024, true
+			}
+			pts = -1
+		}
+		if !t.hasDTS {
+			return nil
+		}
+
+		raw := payload[hdrLen:frameLen]
+		r.startSample(t)
+		if err := r.write(raw); err != nil {
+			return err
+		}
+		t.lastDTS += 1024
+		t.samples = append(t.samples, mp4Sample{size: uint32(len(raw)), dts: t.lastDTS, key: true})
+		payload = payload[frameLen:]
+	}
+	return nil
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// splitAnnexB découpe un flux Annex B (start codes 00 00 01) en NAL units.
+func splitAnnexB(b []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if start >= 0 {
+				nals = appendNAL(nals, b[start:i])
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 {
+		nals = appendNAL(nals, b[start:])
+	}
+	return nals
+}
+
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	if len(nal) < 2 {
+		return nals
+	}
+	return append(nals, nal)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
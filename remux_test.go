@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Écriture de bits pour fabriquer des SPS de test
+type bitWriter struct {
+	buf  []byte
+	nbit int
+}
+
+func (w *bitWriter) bit(v int) {
+	if w.nbit%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if v != 0 {
+		w.buf[len(w.buf)-1] |= 0x80 >> uint(w.nbit%8)
+	}
+	w.nbit++
+}
+
+func (w *bitWriter) bits(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> uint(i) & 1)
+	}
+}
+
+func (w *bitWriter) ue(v int) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+// SPS H.264 baseline 320x240
+func testH264SPS() []byte {
+	w := &bitWriter{}
+	w.bits(66, 8)   // profile_idc
+	w.bits(0xC0, 8) // contraintes
+	w.bits(30, 8)   // level_idc
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(0)         // pic_order_cnt_type
+	w.ue(0)         // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(1)         // max_num_ref_frames
+	w.bit(0)        // gaps_in_frame_num_value_allowed_flag
+	w.ue(19)        // pic_width_in_mbs_minus1
+	w.ue(14)        // pic_height_in_map_units_minus1
+	w.bit(1)        // frame_mbs_only_flag
+	w.bit(1)        // direct_8x8_inference_flag
+	w.bit(0)        // frame_cropping_flag
+	w.bit(0)        // vui_parameters_present_flag
+	w.bit(1)        // rbsp_stop_one_bit
+	return append([]byte{0x67}, w.buf...)
+}
+
+// SPS H.264 coupé juste après pic_order_cnt_type = 1, dont la boucle lit un
+// nombre d'éléments au-delà de la fin des données
+func truncatedPOCType1SPS() []byte {
+	w := &bitWriter{}
+	w.bits(66, 8)
+	w.bits(0xC0, 8)
+	w.bits(30, 8)
+	w.ue(0)
+	w.ue(0)
+	w.ue(1) // pic_order_cnt_type
+	return append([]byte{0x67}, w.buf...)
+}
+
+// tsPackets découpe une charge utile en paquets TS de 188 octets, le dernier
+// complété par un champ d'adaptation de bourrage.
+func tsPackets(pid int, payload []byte) []byte {
+	var out []byte
+	for first := true; first || len(payload) > 0; first = false {
+		hdr := []byte{0x47, byte(pid >> 8 & 0x1F), byte(pid), 0x10}
+		if first {
+			hdr[1] |= 0x40
+		}
+		n := min(len(payload), tsPacketSize-4)
+		if n < tsPacketSize-4 {
+			hdr[3] = 0x30
+			stuffing := tsPacketSize - 4 - n - 1
+			hdr = append(hdr, byte(stuffing))
+			if stuffing > 0 {
+				hdr = append(hdr, 0x00)
+				hdr = append(hdr, bytes.Repeat([]byte{0xFF}, stuffing-1)...)
+			}
+		}
+		out = append(out, hdr...)
+		out = append(out, payload[:n]...)
+		payload = payload[n:]
+	}
+	return out
+}
+
+func pesTimestamp(ts int64) []byte {
+	return []byte{0x21 | byte(ts>>29)&0x0E, byte(ts >> 22), byte(ts>>14) | 1, byte(ts >> 7), byte(ts<<1) | 1}
+}
+
+// buildTestTS fabrique un flux MPEG-TS d'une piste vidéo : PAT, PMT puis une
+// image par groupe de NAL units.
+func buildTestTS(streamType byte, frames ...[][]byte) []byte {
+	pat := []byte{0x00, 0x00, 0xB0, 13, 0x00, 0x01, 0xC1, 0, 0, 0x00, 0x01, 0xF0, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x00, 0x02, 0xB0, 18, 0x00, 0x01, 0xC1, 0, 0, 0xE1, 0x00, 0xF0, 0x00,
+		streamType, 0xE1, 0x00, 0xF0, 0x00, 0, 0, 0, 0}
+	ts := append(tsPackets(0, pat), tsPackets(0x1000, pmt)...)
+	for i, nals := range frames {
+		pes := append([]byte{0, 0, 1, 0xE0, 0, 0, 0x80, 0x80, 5}, pesTimestamp(int64(i)*3000)...)
+		for _, nal := range nals {
+			pes = append(pes, 0, 0, 0, 1)
+			pes = append(pes, nal...)
+		}
+		ts = append(ts, tsPackets(0x100, pes)...)
+	}
+	return ts
+}
+
+func remuxTestTS(t *testing.T, ts []byte) ([]byte, error) {
+	t.Helper()
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "in.ts"), filepath.Join(dir, "out.mp4")
+	if err := os.WriteFile(src, ts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := RemuxTSToMP4(src, dst)
+	out, _ := os.ReadFile(dst)
+	return out, err
+}
+
+func TestRemuxTSToMP4(t *testing.T) {
+	pps := []byte{0x68, 0xCE, 0x38, 0x80}
+	out, err := remuxTestTS(t, buildTestTS(streamTypeH264,
+		[][]byte{testH264SPS(), pps, {0x65, 0x88, 0x84}},
+		[][]byte{{0x41, 0x9A, 0x02}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out[4:], []byte("ftyp")) || !bytes.Contains(out, []byte("avcC")) {
+		t.Fatalf("MP4 incomplet (%d octets)", len(out))
+	}
+	if info := parseH264SPS(testH264SPS()); info.width != 320 || info.height != 240 {
+		t.Errorf("dimensions %dx%d, attendu 320x240", info.width, info.height)
+	}
+}
+
+// Des jeux de paramètres corrompus font échouer le remuxage (repli sur le .ts)
+// sans faire tomber ni bloquer le processus.
+func TestRemuxMalformedParamSets(t *testing.T) {
+	hevcIDR := []byte{0x26, 0x01, 0xAF, 0x10}
+	tests := []struct {
+		name       string
+		streamType byte
+		frame      [][]byte
+		wantErr    bool
+	}{
+		{"SPS H.264 tronqué", streamTypeH264,
+			[][]byte{{0x67, 0x42, 0xC0}, {0x68, 0xCE, 0x38, 0x80}, {0x65, 0x88, 0x84}}, true},
+		{"SPS H.264 coupé dans une boucle", streamTypeH264,
+			[][]byte{truncatedPOCType1SPS(), {0x68, 0xCE, 0x38, 0x80}, {0x65, 0x88, 0x84}}, false},
+		{"PPS H.264 tronqué", streamTypeH264,
+			[][]byte{testH264SPS(), {0x68}, {0x65, 0x88, 0x84}}, true},
+		{"SPS H.265 raccourci par les octets d'échappement", streamTypeH265,
+			[][]byte{
+				{0x40, 0x01, 0x0C},
+				{0x42, 0x01, 0x01, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00},
+				{0x44, 0x01, 0xC1},
+				hevcIDR,
+			}, true},
+		{"SPS H.265 tronqué", streamTypeH265,
+			[][]byte{{0x42, 0x01, 0x01, 0x60}, {0x44, 0x01, 0xC1}, hevcIDR}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := remuxTestTS(t, buildTestTS(tt.streamType, tt.frame))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("erreur %v, attendu une erreur : %v", err, tt.wantErr)
+			}
+		})
+	}
+}
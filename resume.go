@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Manifeste "sidecar" d'un téléchargement M3U8 en cours.
+// Il est stocké à côté du fichier temporaire (.part) et permet de reprendre
+// un téléchargement interrompu (crash, redémarrage) au dernier segment écrit.
+type m3u8Manifest struct {
+	PlaylistURL  string   `json:"playlistUrl"`
+	Segments     []string `json:"segments"`
+	LastIndex    int      `json:"lastIndex"` // -1 si aucun segment n'a encore été écrit
+	BytesWritten int64    `json:"bytesWritten"`
+}
+
+// Chemins du fichier temporaire et de son manifeste pour une destination donnée.
+func partPaths(finalPath string) (string, string) {
+	partPath := finalPath + ".part"
+	return partPath, partPath + ".json"
+}
+
+func loadManifest(path string) (*m3u8Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m m3u8Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// saveManifest écrit le manifeste de façon atomique (fichier temporaire + rename)
+// pour ne jamais laisser un JSON tronqué en cas de coupure.
+func saveManifest(path string, m *m3u8Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// canResume indique si le manifeste correspond bien au même travail.
+func (m *m3u8Manifest) canResume(playlistURL string, segments []string) bool {
+	if m.PlaylistURL != playlistURL || len(m.Segments) != len(segments) {
+		return false
+	}
+	for i := range segments {
+		if m.Segments[i] != segments[i] {
+			return false
+		}
+	}
+	return m.LastIndex >= -1 && m.LastIndex < len(segments)
+}
+
+// openPartFile ouvre (ou crée) le fichier temporaire et le positionne à la fin
+// des données validées par le manifeste. Tout ce qui a été écrit après le
+// dernier point de sauvegarde est tronqué.
+func openPartFile(partPath string, m *m3u8Manifest) (*os.File, error) {
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(m.BytesWritten); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(m.BytesWritten, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version sémantique (https://semver.org) : MAJEUR.MINEUR.CORRECTIF[-prerelease][+build]
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 []string // identifiants de pré-version ("beta", "2"), vide pour une version finale
+}
+
+// ParseVersion lit une version sémantique, avec ou sans "v" devant.
+// Les métadonnées de build (+...) sont ignorées, comme le veut la norme.
+func ParseVersion(s string) (Version, error) {
+	raw := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	raw, _, _ = strings.Cut(raw, "+")
+	core, pre, hasPre := strings.Cut(raw, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("version %q invalide (attendu X.Y.Z)", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return Version{}, fmt.Errorf("version %q invalide", s)
+		}
+		nums[i] = n
+	}
+
+	v := Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	if hasPre {
+		v.Pre = strings.Split(pre, ".")
+		for _, id := range v.Pre {
+			if id == "" {
+				return Version{}, fmt.Errorf("version %q invalide (pré-version vide)", s)
+			}
+		}
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	return s
+}
+
+// Compare renvoie -1, 0 ou 1 selon l'ordre de précédence semver : une
+// pré-version passe avant la version finale (1.1.0-beta < 1.1.0) et ses
+// identifiants numériques se comparent comme des nombres (beta.2 < beta.10).
+func (v Version) Compare(o Version) int {
+	for _, d := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if d[0] != d[1] {
+			return cmpInt(d[0], d[1])
+		}
+	}
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		if c := comparePreID(v.Pre[i], o.Pre[i]); c != 0 {
+			return c
+		}
+	}
+	return cmpInt(len(v.Pre), len(o.Pre))
+}
+
+// Identifiants de pré-version : numériques entre eux, avant les alphanumériques.
+func comparePreID(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmpInt(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"1.0.5", "1.0.5", true},
+		{"v2.10.0", "2.10.0", true},
+		{" 1.1.0-beta.2 ", "1.1.0-beta.2", true},
+		{"1.0.0+build.7", "1.0.0", true},
+		{"1.0.0-rc.1+sha.abc", "1.0.0-rc.1", true},
+
+		{"1.0", "", false},
+		{"1.0.0.0", "", false},
+		{"01.0.0", "", false},
+		{"1.x.0", "", false},
+		{"1.0.0-", "", false},
+		{"1.0.0-beta..1", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseVersion(%q) : erreur %v, attendu ok = %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && v.String() != tt.want {
+			t.Errorf("ParseVersion(%q) = %s, attendu %s", tt.in, v, tt.want)
+		}
+	}
+}
+
+// Ordre de précédence semver, dont les cas qu'une comparaison de chaînes rate
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.10", "1.0.9", 1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.5", "1.0.5", 0},
+		{"1.0.5+a", "1.0.5+b", 0},
+		{"1.1.0-beta", "1.1.0", -1},
+		{"1.1.0-beta.2", "1.1.0-beta.10", -1},
+		{"1.1.0-alpha", "1.1.0-beta", -1},
+		{"1.1.0-beta", "1.1.0-beta.1", -1},
+		{"1.1.0-1", "1.1.0-alpha", -1},
+		{"1.1.0-rc.1", "1.0.9", 1},
+	}
+	for _, tt := range tests {
+		a, errA := ParseVersion(tt.a)
+		b, errB := ParseVersion(tt.b)
+		if errA != nil || errB != nil {
+			t.Fatalf("versions de test invalides : %v, %v", errA, errB)
+		}
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s comparé à %s = %d, attendu %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("%s comparé à %s = %d, attendu %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
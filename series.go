@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marqueurs d'épisode reconnus dans une URL, du plus fiable au moins fiable :
+//   - S01E02, s1e2, S01.E02, S01-E02, S01_E02, /S1/E2/
+//   - saison-1/episode-2, season/1/ep/2, ?season=1&episode=2 (épisode accentué ou non)
+//   - 1x02
+//
+// Chaque marqueur doit être délimité (début, fin ou caractère non
+// alphanumérique) pour ne pas matcher des lettres isolées d'un identifiant.
+var episodeMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._/-]?e(\d{1,4})(?:[^0-9]|$)`),
+	regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:saison|season)[ ._/=-]?(\d{1,2})[^0-9]{1,3}(?:[ée]pisode|ep)[ ._/=-]?(\d{1,4})(?:[^0-9]|$)`),
+	regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^a-z0-9]|$)`),
+}
+
+// parseEpisodeMarker extrait saison et épisode d'une URL de lecture. Seuls le
+// chemin (décodé) et la requête sont examinés, jamais l'hôte.
+func parseEpisodeMarker(rawURL string) (season, episode int, ok bool) {
+	target := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		target = u.Path + "?" + u.RawQuery
+	}
+	for _, re := range episodeMarkers {
+		m := re.FindStringSubmatch(target)
+		if m == nil {
+			continue
+		}
+		season, _ = strconv.Atoi(m[1])
+		episode, _ = strconv.Atoi(m[2])
+		if season > 0 && episode > 0 {
+			return season, episode, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Sources d'un épisode, regroupées depuis la fiche du média
+type sheetEpisode struct {
+	Season  int
+	Episode int
+	Sources []SheetURL
+}
+
+// groupSheetEpisodes range les URLs de la fiche par saison puis épisode
+// (triés). Les URLs sans marqueur reconnaissable sont ignorées.
+func groupSheetEpisodes(urls []SheetURL) []sheetEpisode {
+	index := map[[2]int]int{}
+	var episodes []sheetEpisode
+	for _, u := range urls {
+		s, e, ok := parseEpisodeMarker(u.URL)
+		if !ok {
+			continue
+		}
+		key := [2]int{s, e}
+		i, seen := index[key]
+		if !seen {
+			i = len(episodes)
+			index[key] = i
+			episodes = append(episodes, sheetEpisode{Season: s, Episode: e})
+		}
+		episodes[i].Sources = append(episodes[i].Sources, u)
+	}
+
+	sort.Slice(episodes, func(i, j int) bool {
+		if episodes[i].Season != episodes[j].Season {
+			return episodes[i].Season < episodes[j].Season
+		}
+		return episodes[i].Episode < episodes[j].Episode
+	})
+	return episodes
+}
+
+// Structure normalisée d'un média renvoyée par /api/media/{id}
+type MediaSource struct {
+	URL    string `json:"url"`
+	Name   string `json:"name"`
+	Format string `json:"format"` // m3u8, mp4 ou player
+}
+
+type MediaEpisode struct {
+	Number  int           `json:"number"`
+	Name    string        `json:"name,omitempty"`
+	Sources []MediaSource `json:"sources"`
+}
+
+type MediaSeason struct {
+	Number   int            `json:"number"`
+	Episodes []MediaEpisode `json:"episodes"`
+}
+
+type MediaDetail struct {
+	ID           int           `json:"id"`
+	Title        string        `json:"title"`
+	Type         string        `json:"type"`
+	Year         string        `json:"year,omitempty"`
+	SeasonsCount int           `json:"seasonsCount"`
+	Seasons      []MediaSeason `json:"seasons"`
+	// Sources sans marqueur d'épisode (celles d'un film, par exemple)
+	Sources []MediaSource `json:"sources"`
+}
+
+func sourceFormat(rawURL string) string {
+	switch {
+	case strings.Contains(rawURL, ".m3u8"):
+		return "m3u8"
+	case strings.Contains(rawURL, ".mp4"):
+		return "mp4"
+	default:
+		return "player"
+	}
+}
+
+func toMediaSources(urls []SheetURL) []MediaSource {
+	sources := make([]MediaSource, 0, len(urls))
+	for _, u := range urls {
+		sources = append(sources, MediaSource{URL: u.URL, Name: u.Name, Format: sourceFormat(u.URL)})
+	}
+	return sources
+}
+
+// buildMediaDetail construit la structure saison -> épisode -> sources d'un
+// média. Les noms d'épisodes viennent de l'API des saisons ; s'ils sont
+// indisponibles, les épisodes restent simplement sans nom.
+func buildMediaDetail(ctx context.Context, mediaID string) (MediaDetail, error) {
+	sheet, err := api.Sheet(ctx, mediaID)
+	if err != nil {
+		return MediaDetail{}, err
+	}
+	items := sheet.Data.Items
+	sheetHosts.Remember(items.Urls)
+	detail := MediaDetail{
+		ID:           items.ID,
+		Title:        items.Title,
+		Type:         items.Type,
+		Year:         yearOf(items.ReleaseDate),
+		SeasonsCount: items.Seasons,
+		Seasons:      []MediaSeason{},
+	}
+
+	var unsorted []SheetURL
+	for _, u := range items.Urls {
+		if _, _, ok := parseEpisodeMarker(u.URL); !ok {
+			unsorted = append(unsorted, u)
+		}
+	}
+	detail.Sources = toMediaSources(unsorted)
+
+	for _, ep := range groupSheetEpisodes(items.Urls) {
+		if n := len(detail.Seasons); n == 0 || detail.Seasons[n-1].Number != ep.Season {
+			detail.Seasons = append(detail.Seasons, MediaSeason{Number: ep.Season})
+		}
+		season := &detail.Seasons[len(detail.Seasons)-1]
+		season.Episodes = append(season.Episodes, MediaEpisode{Number: ep.Episode, Sources: toMediaSources(ep.Sources)})
+	}
+
+	// Noms des épisodes, une requête par saison en parallèle
+	var wg sync.WaitGroup
+	for i := range detail.Seasons {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			season := &detail.Seasons[i]
+			names, err := api.Season(ctx, strconv.Itoa(items.ID), season.Number)
+			if err != nil {
+				log.Printf("Noms des épisodes de la saison %d indisponibles : %v", season.Number, err)
+				return
+			}
+			byNumber := make(map[int]string, len(names))
+			for _, e := range names {
+				byNumber[e.Number] = e.Name
+			}
+			for j := range season.Episodes {
+				season.Episodes[j].Name = byNumber[season.Episodes[j].Number]
+			}
+		}()
+	}
+	wg.Wait()
+	return detail, nil
+}
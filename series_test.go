@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// Formes d'URL rencontrées dans les fiches, et faux positifs à éviter
+func TestParseEpisodeMarker(t *testing.T) {
+	tests := []struct {
+		name            string
+		url             string
+		season, episode int
+		ok              bool
+	}{
+		{"S01E02", "https://cdn.example.com/show/Show.S01E02.1080p.mp4", 1, 2, true},
+		{"s1/e2", "https://cdn.example.com/show/s1/e2/index.m3u8", 1, 2, true},
+		{"S1/E2", "https://cdn.example.com/show/S1/E2/index.m3u8", 1, 2, true},
+		{"s01.e02", "https://cdn.example.com/show/show.s01.e02.mp4", 1, 2, true},
+		{"saison-1/episode-2", "https://cdn.example.com/serie/saison-1/episode-2", 1, 2, true},
+		{"épisode accentué", "https://cdn.example.com/serie/saison-3/%C3%A9pisode-12", 3, 12, true},
+		{"requête season/episode", "https://cdn.example.com/play?season=1&episode=2", 1, 2, true},
+		{"1x02", "https://cdn.example.com/show/show-1x02.mp4", 1, 2, true},
+		{"épisode à 3 chiffres", "https://cdn.example.com/anime/S01E112.mp4", 1, 112, true},
+
+		{"résolution", "https://cdn.example.com/movie/movie-1920x1080.mp4", 0, 0, false},
+		{"marqueur collé à des lettres", "https://cdn.example.com/abcdefS3E4/video.mp4", 0, 0, false},
+		{"marqueur dans l'hôte", "https://s01e02.example.com/video.mp4", 0, 0, false},
+		{"film sans marqueur", "https://cdn.example.com/movie/inception.mp4", 0, 0, false},
+		{"saison ou épisode nul", "https://cdn.example.com/show/S00E00.mp4", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			season, episode, ok := parseEpisodeMarker(tt.url)
+			if ok != tt.ok || season != tt.season || episode != tt.episode {
+				t.Errorf("parseEpisodeMarker(%q) = %d, %d, %v ; attendu %d, %d, %v",
+					tt.url, season, episode, ok, tt.season, tt.episode, tt.ok)
+			}
+		})
+	}
+}
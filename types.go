@@ -83,6 +83,8 @@ type SheetResponse struct {
 			Title   string     `json:"title"`
 			Urls    []SheetURL `json:"urls"`
 			Seasons int        `json:"seasons"`
+			// Date de sortie, pour l'année dans les noms de fichiers
+			ReleaseDate string `json:"release_date"`
 		} `json:"items"`
 	} `json:"data"`
 }
@@ -14,6 +14,55 @@ fetch('/api/config')
         isDocker = config.isDocker;
     });
 
+/* --------------------------------------------------------------
+    Mise à jour (vérifiée en arrière-plan par le serveur)
+-------------------------------------------------------------- */
+async function loadUpdateStatus() {
+    try {
+        const res = await fetch('/api/update');
+        const status = await res.json();
+        if (status.state === 'checking') {
+            setTimeout(loadUpdateStatus, 5000); // vérification pas encore terminée
+            return;
+        }
+        if (status.available) showUpdateBanner(status);
+    } catch (e) { /* pas de bandeau si le serveur ne répond pas */ }
+}
+loadUpdateStatus();
+
+// Affiche la mise à jour disponible et ses notes de version
+function showUpdateBanner(status) {
+    const update = status.available;
+    let title = update.required
+        ? `Mise à jour obligatoire : ${update.version} (version actuelle ${update.current} plus supportée)`
+        : `Nouvelle version disponible : ${update.version} (actuelle : ${update.current})`;
+    if (status.state === 'installed') title = `Version ${update.version} installée : relancez l'application pour l'utiliser.`;
+    if (status.error) title += ` — échec de l'installation : ${status.error}`;
+
+    document.getElementById('update-title').textContent = title;
+    document.getElementById('update-notes').textContent = update.notes || '';
+    // En mode Docker, c'est l'image qu'il faut mettre à jour
+    document.getElementById('update-install').hidden = !status.selfUpdate || status.state !== 'available';
+    document.getElementById('update-banner').hidden = false;
+}
+
+async function installUpdate() {
+    const btn = document.getElementById('update-install');
+    btn.disabled = true;
+    btn.textContent = 'Installation...';
+    try {
+        const res = await fetch('/api/update', { method: 'POST' });
+        if (!res.ok) throw new Error(await res.text());
+        showUpdateBanner(await res.json());
+    } catch (e) {
+        alert('Mise à jour impossible : ' + e.message);
+        loadUpdateStatus();
+    } finally {
+        btn.disabled = false;
+        btn.textContent = 'Installer';
+    }
+}
+
 /* --------------------------------------------------------------
     Logique de Recherche
 -------------------------------------------------------------- */
@@ -126,21 +175,23 @@ async function loadLastReleases() {
 
 async function handleMediaClick(m) {
     try {
-        const res = await fetch(`/api/download?detail=${m.id}&infoOnly=true`);
-        const sheet = await res.json();
-        const data = sheet.data.items;
-
-        // On utilise le titre de l'API en priorité pour éviter les 'undefined'
-        const mediaTitle = data.title || m.title;
-
         if (m.kind === "movie") {
-            showSourceSelector(mediaTitle, data.urls, m.id);
+            const res = await fetch(`/api/download?detail=${m.id}&infoOnly=true`);
+            const sheet = await res.json();
+            const data = sheet.data.items;
+
+            // On utilise le titre de l'API en priorité pour éviter les 'undefined'
+            const meta = { year: (data.release_date || '').slice(0, 4) };
+            showSourceSelector(data.title || m.title, data.urls, m.id, meta);
         } else {
-            const organizedData = organizeSeries(sheet);
-            
+            // Structure saisons -> épisodes -> sources construite par le serveur
+            const res = await fetch(`/api/media/${m.id}`);
+            if (!res.ok) throw new Error(await res.text());
+            const detail = await res.json();
+
             // On met à jour l'objet media avec le vrai titre pour les fonctions suivantes
-            const updatedMedia = { ...m, title: mediaTitle };
-            showSeasonSelectorFromData(updatedMedia, organizedData);
+            const updatedMedia = { ...m, title: detail.title || m.title, year: detail.year };
+            showSeasonSelectorFromData(updatedMedia, detail);
         }
     } catch (e) {
         console.error(e);
@@ -148,41 +199,24 @@ async function handleMediaClick(m) {
     }
 }
 
-function organizeSeries(data) {
-    const items = data.data.items;
-    const organized = {};
-
-    items.urls.forEach(link => {
-        // Regex pour capturer S01E02 ou /S1/E2/
-        const sMatch = link.url.match(/S(\d+)/i);
-        const eMatch = link.url.match(/E(\d+)/i);
-
-        if (sMatch && eMatch) {
-            const sNum = parseInt(sMatch[1]);
-            const eNum = parseInt(eMatch[1]);
-
-            if (!organized[sNum]) organized[sNum] = {};
-            if (!organized[sNum][eNum]) organized[sNum][eNum] = [];
-
-            organized[sNum][eNum].push({
-                url: link.url,
-                name: link.name
-            });
-        }
-    });
-
-    return {
-        title: items.title,
-        seasonsCount: items.seasons,
-        content: organized
-    };
-}
-
 /* --------------------------------------------------------------
     Sélecteur de Sources (Qualités / Formats)
 -------------------------------------------------------------- */
 
-async function showSourceSelector(title, urls, mediaId, season = "", episode = "") {
+/**
+ * Paramètres servant au nom du fichier téléchargé (modèles de la bibliothèque)
+ * @param {object} meta - { mediaTitle, year, season, episode, episodeName }
+ * @param {string} sourceName - Le nom de la source choisie
+ */
+function mediaParams(meta, sourceName) {
+    const params = new URLSearchParams({ source: sourceName || '' });
+    Object.entries(meta).forEach(([key, value]) => {
+        if (value !== undefined && value !== null && value !== '') params.set(key, value);
+    });
+    return params.toString();
+}
+
+async function showSourceSelector(title, urls, mediaId, meta = {}) {
     const modal = document.getElementById('source-modal');
     const list = document.getElementById('source-list');
     document.getElementById('modal-title').textContent = title;
@@ -235,10 +269,13 @@ async function showSourceSelector(title, urls, mediaId, season = "", episode = "
         li.querySelector('.btn-download').onclick = (e) => {
             e.stopPropagation();
             if (isM3U8) {
-                handleM3U8Download(source.url, title);
+                handleM3U8Download(source.url, title, mediaParams(meta, source.name));
+            } else if (isMP4 && !isDocker) {
+                // MP4 direct : téléchargé par le serveur (file, plusieurs connexions, reprise)
+                handleM3U8Download(source.url, title, mediaParams(meta, source.name), '/api/mp4-download');
             } else {
                 // Pour le MP4, on force le téléchargement via l'API ou un attribut
-                const downloadUrl = `/api/download?detail=${mediaId}&selectedUrl=${encodeURIComponent(source.url)}&title=${encodeURIComponent(title)}`;
+                const downloadUrl = `/api/download?detail=${mediaId}&selectedUrl=${encodeURIComponent(source.url)}&title=${encodeURIComponent(title)}&${mediaParams(meta, source.name)}`;
                 window.location.href = downloadUrl;
             }
             closeModal();
@@ -252,9 +289,11 @@ async function showSourceSelector(title, urls, mediaId, season = "", episode = "
 /**
  * Gère le processus de téléchargement des flux M3U8 (HLS)
  * @param {string} url - L'adresse du flux .m3u8
- * @param {string} title - Le nom du média pour le fichier final
+ * @param {string} title - Le nom du média affiché pendant le suivi
+ * @param {string} params - Les paramètres du nom de fichier (voir mediaParams)
+ * @param {string} endpoint - /api/m3u8-download, ou /api/mp4-download pour une source MP4 directe
  */
-async function handleM3U8Download(url, title) {
+async function handleM3U8Download(url, title, params = '', endpoint = '/api/m3u8-download') {
     const toast = document.getElementById('m3u8-toast');
     const statusText = document.getElementById('m3u8-status-text');
     
@@ -265,39 +304,47 @@ async function handleM3U8Download(url, title) {
     }
 
     try {
-        // 2. Appeler ton API backend pour démarrer la conversion/téléchargement
-        // On ne met pas de "await" ici si l'API est asynchrone et répond immédiatement "OK"
-        fetch(`/api/m3u8-download?url=${encodeURIComponent(url)}&title=${encodeURIComponent(title)}`);
-
-        // 3. Créer une boucle de vérification (Polling)
-        const checker = setInterval(async () => {
-            try {
-                const res = await fetch(`/api/m3u8-status?title=${encodeURIComponent(title)}`);
-                
-                if (!res.ok) throw new Error("Erreur serveur");
-                
-                const data = await res.json();
-                
-                // Mise à jour du texte (ex: "15%", "Conversion en cours...", etc.)
-                if (statusText) {
-                    statusText.textContent = data.status;
+        // 2. Appeler ton API backend : il renvoie le job créé (avec son ID unique)
+        const startRes = await fetch(`${endpoint}?url=${encodeURIComponent(url)}&title=${encodeURIComponent(title)}&${params}`);
+        if (!startRes.ok) throw new Error(await startRes.text());
+        const job = await startRes.json();
+        bindJobControls(job.id);
+
+        // 3. Suivre ce job précis en direct (Server-Sent Events)
+        const stream = new EventSource(`/api/events?job=${job.id}`);
+        const render = (data) => {
+            // Mise à jour du texte (ex: "Téléchargement : 15/300 segments")
+            if (statusText) {
+                statusText.textContent = data.status;
+                // Variante choisie dans la master playlist (ex: 1920x1080)
+                if (data.variant && data.variant.height) {
+                    statusText.textContent += ` (${data.variant.width}x${data.variant.height})`;
                 }
+            }
 
-                // 4. Si le serveur indique que c'est fini
-                if (data.status === "Terminé !" || data.completed === true) {
-                    clearInterval(checker);
-                    
-                    // Optionnel : masquer le toast après un délai
-                    setTimeout(() => { 
-                        if (toast) toast.style.display = 'none'; 
-                    }, 5000);
-                }
-            } catch (err) {
-                console.error("Erreur lors de la vérification du statut:", err);
-                if (statusText) statusText.textContent = "Erreur de suivi";
-                clearInterval(checker);
+            // 4. Si le job est terminé (succès, échec ou annulation)
+            if (["completed", "failed", "cancelled"].includes(data.state)) {
+                stream.close();
+
+                // Optionnel : masquer le toast après un délai
+                setTimeout(() => { 
+                    if (toast) toast.style.display = 'none'; 
+                }, 5000);
             }
-        }, 1000); // Vérification toutes les secondes
+        };
+
+        stream.addEventListener('snapshot', (e) => JSON.parse(e.data).forEach(render));
+        ["queued", "started", "progress", "paused", "resumed", "completed", "failed", "cancelled"].forEach(type => {
+            stream.addEventListener(type, (e) => render(JSON.parse(e.data).job));
+        });
+        stream.addEventListener('retrying', (e) => {
+            const evt = JSON.parse(e.data);
+            if (statusText) statusText.textContent = `Nouvel essai (${evt.attempt}/5) : ${evt.segment}`;
+        });
+        stream.onerror = () => {
+            // EventSource se reconnecte tout seul ; on le signale simplement
+            if (statusText && stream.readyState === EventSource.CONNECTING) statusText.textContent = "Reconnexion au suivi...";
+        };
 
     } catch (error) {
         console.error("Impossible de lancer le téléchargement M3U8:", error);
@@ -305,6 +352,48 @@ async function handleM3U8Download(url, title) {
     }
 }
 
+/**
+ * Met en file tous les épisodes d'une saison (ou "all" pour la série entière)
+ * @param {number} mediaId - L'ID du média
+ * @param {number|string} season - Le numéro de saison
+ */
+async function handleBatchDownload(mediaId, season) {
+    try {
+        const res = await fetch(`/api/batch-download?id=${mediaId}&season=${season}`, { method: 'POST' });
+        if (!res.ok) throw new Error(await res.text());
+        const batch = await res.json();
+
+        let message = `${batch.queued.length} épisode(s) ajouté(s) à la file.`;
+        if (batch.skipped.length > 0) {
+            message += `\nIgnorés : ` + batch.skipped.map(s => `S${pad(s.season)}E${pad(s.episode)} (${s.reason})`).join(', ');
+        }
+        alert(message);
+    } catch (error) {
+        console.error("Impossible de lancer le téléchargement groupé:", error);
+        alert("Erreur lors du téléchargement groupé.");
+    }
+}
+
+/**
+ * Branche les boutons Pause / Annuler du toast sur le job en cours
+ * @param {string} jobId - L'identifiant renvoyé par /api/m3u8-download
+ */
+function bindJobControls(jobId) {
+    const pauseBtn = document.getElementById('m3u8-pause-btn');
+    const cancelBtn = document.getElementById('m3u8-cancel-btn');
+    let paused = false;
+
+    pauseBtn.textContent = '⏸ Pause';
+    pauseBtn.onclick = async () => {
+        const res = await fetch(`/api/jobs/${jobId}/${paused ? 'resume' : 'pause'}`, { method: 'POST' });
+        if (res.ok) {
+            paused = !paused;
+            pauseBtn.textContent = paused ? '▶ Reprendre' : '⏸ Pause';
+        }
+    };
+    cancelBtn.onclick = () => fetch(`/api/jobs/${jobId}/cancel`, { method: 'POST' });
+}
+
 async function checkLinkStatus(url, index) {
     const dot = document.getElementById(`dot-${index}`);
     const row = document.getElementById(`source-item-${index}`);
@@ -373,8 +462,8 @@ function renderMediaList(items) {
     Sélecteur de Saisons et Épisodes
 -------------------------------------------------------------- */
 
-function showSeasonSelectorFromData(media, organizedData) {
-    results.innerHTML = `<h3 class="season-header">${organizedData.title}</h3>`;
+function showSeasonSelectorFromData(media, detail) {
+    results.innerHTML = `<h3 class="season-header">${detail.title}</h3>`;
     
     // Bouton retour à la recherche
     const backBtn = document.createElement('li');
@@ -384,19 +473,17 @@ function showSeasonSelectorFromData(media, organizedData) {
     backBtn.onclick = () => search.oninput();
     results.appendChild(backBtn);
 
-    // On affiche les saisons trouvées par le Regex
-    const seasons = Object.keys(organizedData.content).sort((a, b) => a - b);
-    
-    seasons.forEach(sNum => {
+    // On affiche les saisons trouvées par le serveur (déjà triées)
+    detail.seasons.forEach(season => {
         const li = document.createElement('li');
         li.className = 'season-item';
-        li.innerHTML = `<span class="season-label">Saison ${sNum}</span>`;
-        li.onclick = () => renderEpisodesFromData(media, sNum, organizedData.content[sNum]);
+        li.innerHTML = `<span class="season-label">Saison ${season.number}</span>`;
+        li.onclick = () => renderEpisodesFromData(media, season.number, season.episodes);
         results.appendChild(li);
     });
 }
 
-function renderEpisodesFromData(media, sNum, episodesMap) {
+function renderEpisodesFromData(media, sNum, episodes) {
     // Correction : On repasse l'objet 'media' complet au clic sur le retour
     const backLi = document.createElement('li');
     backLi.style.width = "100%";
@@ -407,18 +494,32 @@ function renderEpisodesFromData(media, sNum, episodesMap) {
     results.innerHTML = '';
     results.appendChild(backLi);
 
-    const episodes = Object.keys(episodesMap).sort((a, b) => a - b);
+    // Téléchargement groupé : le serveur choisit la meilleure source de chaque épisode
+    if (!isDocker) {
+        const batchLi = document.createElement('li');
+        batchLi.style.width = "100%";
+        batchLi.innerHTML = `<span class="title">⬇ Télécharger toute la saison ${sNum}</span>`;
+        batchLi.onclick = () => handleBatchDownload(media.id, sNum);
+        results.appendChild(batchLi);
+    }
 
-    episodes.forEach(eNum => {
+    episodes.forEach(episode => {
         const li = document.createElement('li');
         li.style.width = "100%";
-        const sources = episodesMap[eNum];
+        const eNum = episode.number;
+        const name = episode.name ? ` - ${episode.name}` : '';
         
-        li.innerHTML = `<span class="title">Épisode ${pad(eNum)}</span>`;
+        li.innerHTML = `<span class="title">Épisode ${pad(eNum)}${name}</span>`;
         li.onclick = () => {
             // Ici media.title sera bien défini
             const finalTitle = `${media.title} S${pad(sNum)}E${pad(eNum)}`;
-            showSourceSelector(finalTitle, sources, media.id);
+            showSourceSelector(finalTitle, episode.sources, media.id, {
+                mediaTitle: media.title,
+                year: media.year,
+                season: sNum,
+                episode: eNum,
+                episodeName: episode.name
+            });
         };
         results.appendChild(li);
     });
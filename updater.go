@@ -1,74 +1,497 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
-func CheckForUpdates() {
-	resp, err := http.Get(UpdateConfigURL)
+// Clé publique ed25519 (base64) qui signe les mises à jour, intégrée au
+// binaire à la compilation :
+//
+//	go build -ldflags "-X main.UpdatePublicKey=<clé>" .
+//
+// Sans clé, aucune mise à jour n'est installée.
+var UpdatePublicKey = ""
+
+// Canaux de mise à jour (réglable : updates.channel)
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+var UpdateChannel = ChannelStable
+
+// Binaire d'une version pour une plateforme
+type updateAsset struct {
+	URL       string `json:"url"`
+	SHA256    string `json:"sha256"`    // empreinte hexadécimale du binaire
+	Signature string `json:"signature"` // signature ed25519 (base64) du binaire
+}
+
+// Une version publiée dans update.json
+type updateManifest struct {
+	Version        string                 `json:"version"`
+	Assets         map[string]updateAsset `json:"assets,omitempty"`         // par plateforme : "linux/amd64", "windows/amd64"...
+	Notes          string                 `json:"notes,omitempty"`          // notes de version affichées avant l'installation
+	MinimumVersion string                 `json:"minimumVersion,omitempty"` // versions antérieures obligées de se mettre à jour
+
+	// Ancien format : un seul binaire, toujours l'exécutable Windows
+	URL       string `json:"url,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// platformKey identifie la plateforme de ce binaire dans assets.
+func platformKey() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// asset renvoie le binaire de m destiné à cette plateforme.
+func (m updateManifest) asset() (updateAsset, bool) {
+	if a, ok := m.Assets[platformKey()]; ok && a.URL != "" {
+		return a, true
+	}
+	if len(m.Assets) == 0 && m.URL != "" && runtime.GOOS == "windows" {
+		return updateAsset{URL: m.URL, SHA256: m.SHA256, Signature: m.Signature}, true
+	}
+	return updateAsset{}, false
+}
+
+// Contenu de update.json : une version par canal. L'ancien format (une
+// seule version à la racine) reste accepté comme canal stable.
+type updateFeed struct {
+	updateManifest
+	Channels map[string]updateManifest `json:"channels"`
+}
+
+// UpdateInfo décrit la mise à jour disponible.
+type UpdateInfo struct {
+	Current  string `json:"current"`
+	Version  string `json:"version"`
+	Channel  string `json:"channel"`
+	Notes    string `json:"notes,omitempty"`
+	Required bool   `json:"required"` // version actuelle sous la version minimale supportée
+}
+
+// États de la mise à jour, exposés par /api/update
+const (
+	UpdateIdle       = "idle"
+	UpdateChecking   = "checking"
+	UpdateUpToDate   = "up-to-date"
+	UpdateAvailable  = "available"
+	UpdateInstalling = "installing"
+	UpdateInstalled  = "installed" // actif au prochain démarrage
+	UpdateCheckError = "error"
+)
+
+// Délai maximal de lecture de update.json
+var UpdateCheckTimeout = 15 * time.Second
+
+// UpdateStatus est l'état renvoyé par GET /api/update.
+type UpdateStatus struct {
+	Current    string      `json:"current"`
+	Channel    string      `json:"channel"`
+	State      string      `json:"state"`
+	CheckedAt  time.Time   `json:"checkedAt,omitzero"`
+	Error      string      `json:"error,omitempty"`
+	Available  *UpdateInfo `json:"available"`  // null si l'application est à jour
+	SelfUpdate bool        `json:"selfUpdate"` // false en mode Docker : c'est l'image qu'il faut mettre à jour
+}
+
+var (
+	updateMu       sync.Mutex
+	updateState    = UpdateStatus{State: UpdateIdle}
+	pendingRelease updateManifest // version à installer, si Available n'est pas nil
+)
+
+// UpdateState renvoie une copie de l'état de la mise à jour.
+func UpdateState() UpdateStatus {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	st := updateState
+	st.Current = CurrentVersion
+	st.Channel = UpdateChannel
+	st.SelfUpdate = selfUpdateEnabled()
+	return st
+}
+
+// La mise à jour du binaire n'a pas de sens dans un conteneur
+func selfUpdateEnabled() bool {
+	return !isDocker
+}
+
+var (
+	ErrSelfUpdateDisabled = errors.New("mise à jour automatique désactivée en mode Docker : mettez à jour l'image")
+	ErrNoUpdate           = errors.New("aucune mise à jour disponible")
+	ErrUpdateBusy         = errors.New("mise à jour déjà en cours d'installation")
+)
+
+// ErrUpdateRejected signale un binaire téléchargé qui ne passe pas la vérification.
+var ErrUpdateRejected = errors.New("mise à jour rejetée")
+
+// selectRelease choisit la version la plus récente parmi les canaux suivis
+// (stable seul, ou stable et beta) qui publie un binaire pour cette
+// plateforme. required indique si la version actuelle est sous une version
+// minimale annoncée par l'un de ces canaux.
+func selectRelease(feed updateFeed, channel string, current Version) (best updateManifest, found, required bool) {
+	candidates := []updateManifest{}
+	if m, ok := feed.Channels[ChannelStable]; ok {
+		candidates = append(candidates, m)
+	} else if feed.Version != "" {
+		candidates = append(candidates, feed.updateManifest)
+	}
+	if m, ok := feed.Channels[ChannelBeta]; ok && channel == ChannelBeta {
+		candidates = append(candidates, m)
+	}
+
+	var bestVersion Version
+	for _, m := range candidates {
+		if m.MinimumVersion != "" {
+			if min, err := ParseVersion(m.MinimumVersion); err != nil {
+				log.Printf("update.json : %v", err)
+			} else if current.Compare(min) < 0 {
+				required = true
+			}
+		}
+		v, err := ParseVersion(m.Version)
+		if err != nil {
+			log.Printf("update.json : %v", err)
+			continue
+		}
+		if _, ok := m.asset(); !ok {
+			log.Printf("update.json : pas de binaire %s pour la version %s", platformKey(), m.Version)
+			continue
+		}
+		if !found || v.Compare(bestVersion) > 0 {
+			best, bestVersion, found = m, v, true
+		}
+	}
+	if !found || bestVersion.Compare(current) <= 0 {
+		return updateManifest{}, false, false
+	}
+	return best, true, required
+}
+
+// CheckForUpdates lit update.json (en UpdateCheckTimeout au plus) et
+// enregistre la mise à jour disponible, sans rien installer. Renvoie nil si
+// l'application est à jour.
+func CheckForUpdates(ctx context.Context) (*UpdateInfo, error) {
+	current, err := ParseVersion(CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+	setUpdateState(func(st *UpdateStatus) { st.State = UpdateChecking })
+
+	m, found, required, err := fetchRelease(ctx, current)
+	if err != nil {
+		setUpdateState(func(st *UpdateStatus) {
+			st.State, st.Error, st.CheckedAt = UpdateCheckError, err.Error(), time.Now()
+		})
+		return nil, err
+	}
+	var info *UpdateInfo
+	if found {
+		info = &UpdateInfo{Current: CurrentVersion, Version: m.Version, Channel: UpdateChannel, Notes: m.Notes, Required: required}
+	}
+	setUpdateState(func(st *UpdateStatus) {
+		st.State, st.Error, st.CheckedAt, st.Available = UpdateUpToDate, "", time.Now(), info
+		if info != nil {
+			st.State = UpdateAvailable
+			pendingRelease = m
+		}
+	})
+	return info, nil
+}
+
+func setUpdateState(change func(st *UpdateStatus)) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	change(&updateState)
+}
+
+// fetchRelease télécharge update.json et y choisit la version à proposer.
+func fetchRelease(ctx context.Context, current Version) (m updateManifest, found, required bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, UpdateCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", UpdateConfigURL, nil)
+	if err != nil {
+		return m, false, false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return
+		return m, false, false, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return m, false, false, fmt.Errorf("update.json : statut HTTP %d", resp.StatusCode)
+	}
+
+	var feed updateFeed
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return m, false, false, fmt.Errorf("update.json illisible : %v", err)
+	}
+	m, found, required = selectRelease(feed, UpdateChannel, current)
+	return m, found, required, nil
+}
 
-	var updateInfo struct {
-		Version string `json:"version"`
-		URL     string `json:"url"`
+// ApplyUpdate installe la mise à jour trouvée par la dernière vérification.
+// Le binaire est remplacé sur le disque ; la nouvelle version sert au
+// prochain démarrage, le processus en cours n'est pas interrompu.
+func ApplyUpdate() (UpdateInfo, error) {
+	updateMu.Lock()
+	switch {
+	case !selfUpdateEnabled():
+		updateMu.Unlock()
+		return UpdateInfo{}, ErrSelfUpdateDisabled
+	case updateState.State == UpdateInstalling:
+		updateMu.Unlock()
+		return UpdateInfo{}, ErrUpdateBusy
+	case updateState.Available == nil || updateState.State == UpdateInstalled:
+		updateMu.Unlock()
+		return UpdateInfo{}, ErrNoUpdate
 	}
-	json.NewDecoder(resp.Body).Decode(&updateInfo)
+	info, m := *updateState.Available, pendingRelease
+	updateState.State, updateState.Error = UpdateInstalling, ""
+	updateMu.Unlock()
 
-	if updateInfo.Version > CurrentVersion {
-		fmt.Printf("Nouvelle version détectée : %s. Mise à jour en cours...\n", updateInfo.Version)
-		err := doUpdate(updateInfo.URL)
+	err := doUpdate(m)
+	setUpdateState(func(st *UpdateStatus) {
 		if err != nil {
-			log.Printf("Erreur MAJ: %v", err)
+			st.State, st.Error = UpdateAvailable, err.Error()
 		} else {
-			fmt.Println("Mise à jour terminée. Relancez l'application.")
-			time.Sleep(2 * time.Second)
-			os.Exit(0)
+			st.State = UpdateInstalled
+		}
+	})
+	return info, err
+}
+
+// announceUpdate affiche la nouvelle version et ses notes dans la console.
+func announceUpdate(info *UpdateInfo) {
+	fmt.Printf("Nouvelle version disponible : %s (actuelle : %s)\n", info.Version, info.Current)
+	if info.Notes != "" {
+		fmt.Printf("Notes de version :\n%s\n", info.Notes)
+	}
+}
+
+// StartUpdateCheck vérifie les mises à jour en arrière-plan, sans retarder
+// le démarrage du serveur. Une mise à jour disponible, même obligatoire, est
+// seulement annoncée (console, /api/update, bandeau de l'UI) : l'installation
+// reste une action de l'utilisateur.
+func StartUpdateCheck() {
+	setUpdateState(func(st *UpdateStatus) { st.State = UpdateChecking }) // avant que l'UI ne puisse interroger /api/update
+	go func() {
+		info, err := CheckForUpdates(context.Background())
+		if err != nil {
+			log.Printf("Vérification des mises à jour impossible : %v", err)
+			return
+		}
+		if info == nil {
+			return
 		}
+		announceUpdate(info)
+		switch {
+		case !selfUpdateEnabled():
+			if info.Required {
+				fmt.Println("Cette version n'est plus supportée : mettez à jour l'image Docker.")
+			}
+		case info.Required:
+			fmt.Println("Cette version n'est plus supportée : installez la mise à jour (bouton dans l'interface, POST /api/update ou `xaladownloader update`).")
+		default:
+			fmt.Println("Installation : bouton dans l'interface, POST /api/update ou `xaladownloader update`.")
+		}
+	}()
+}
+
+// verifyUpdate contrôle l'empreinte SHA-256 puis la signature ed25519 du
+// binaire téléchargé, avec la clé publique intégrée.
+func verifyUpdate(data []byte, a updateAsset) error {
+	key, err := base64.StdEncoding.DecodeString(UpdatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w : aucune clé publique valide dans ce binaire", ErrUpdateRejected)
+	}
+	want, err := hex.DecodeString(strings.TrimSpace(a.SHA256))
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("%w : empreinte SHA-256 absente ou invalide", ErrUpdateRejected)
 	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], want) {
+		return fmt.Errorf("%w : empreinte SHA-256 différente (%x)", ErrUpdateRejected, sum)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(a.Signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w : signature absente ou invalide", ErrUpdateRejected)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return fmt.Errorf("%w : signature incorrecte", ErrUpdateRejected)
+	}
+	return nil
 }
 
-func doUpdate(url string) error {
-	// 1. Obtenir le chemin de l'exécutable actuel
-	executablePath, _ := os.Executable()
+func doUpdate(m updateManifest) error {
+	a, ok := m.asset()
+	if !ok {
+		return fmt.Errorf("aucun binaire %s pour la version %s", platformKey(), m.Version)
+	}
+
+	// 1. Obtenir le chemin réel de l'exécutable actuel
+	executablePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(executablePath); err == nil {
+		executablePath = resolved
+	}
 	oldPath := executablePath + ".old"
 
-	// 2. Télécharger le nouveau binaire
-	resp, err := http.Get(url)
+	// 2. Télécharger le nouveau binaire en mémoire pour le vérifier avant d'y toucher
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Get(a.URL)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-
-	// 3. Renommer l'actuel pour libérer la place
-	os.Remove(oldPath) // Supprime une ancienne sauvegarde si elle existe
-	err = os.Rename(executablePath, oldPath)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("téléchargement de la mise à jour : statut HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
-	// 4. Créer le nouveau fichier à l'emplacement d'origine
-	newFile, err := os.Create(executablePath)
+	// 3. Empreinte et signature : un binaire non vérifié n'est jamais installé
+	if err := verifyUpdate(data, a); err != nil {
+		log.Printf("Mise à jour %s depuis %s refusée : %v", m.Version, a.URL, err)
+		return err
+	}
+
+	// 4. Écrire le binaire vérifié dans un fichier temporaire du même dossier,
+	// forcé sur le disque avant de remplacer quoi que ce soit
+	newPath, err := writeTempBinary(filepath.Dir(executablePath), data)
 	if err != nil {
 		return err
 	}
-	defer newFile.Close()
 
-	_, err = io.Copy(newFile, resp.Body)
+	// 5. Remplacer l'actuel, gardé en .old
+	if err := swapBinary(executablePath, newPath, oldPath); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	// 6. Le nouveau binaire doit démarrer et annoncer la bonne version, sinon retour à l'ancien
+	if err := selfCheck(executablePath, m.Version); err != nil {
+		if rbErr := rollbackBinary(executablePath, oldPath); rbErr != nil {
+			return fmt.Errorf("le nouveau binaire ne démarre pas (%v) et la restauration de %s a échoué : %v", err, oldPath, rbErr)
+		}
+		return fmt.Errorf("le nouveau binaire ne démarre pas (%v) : version %s restaurée", err, CurrentVersion)
+	}
+	return nil
+}
+
+// writeTempBinary écrit data dans un fichier temporaire exécutable de dir et
+// le synchronise sur le disque.
+func writeTempBinary(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, ".xaladownloader-*.new")
 	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// swapBinary installe newPath à la place de exe et garde l'ancien en oldPath.
+// Hors Windows, l'ancien est d'abord lié en oldPath puis remplacé d'un seul
+// rename : exe existe à tout instant. Windows interdit de remplacer un
+// exécutable en cours d'utilisation mais permet de le renommer.
+func swapBinary(exe, newPath, oldPath string) error {
+	os.Remove(oldPath) // Supprime une ancienne sauvegarde si elle existe
+	if runtime.GOOS != "windows" {
+		if err := os.Link(exe, oldPath); err == nil {
+			if err := os.Rename(newPath, exe); err != nil {
+				os.Remove(oldPath)
+				return err
+			}
+			syncDir(filepath.Dir(exe))
+			return nil
+		}
+	}
+	if err := os.Rename(exe, oldPath); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		os.Rename(oldPath, exe)
+		return err
+	}
+	syncDir(filepath.Dir(exe))
+	return nil
+}
+
+// rollbackBinary remet la sauvegarde oldPath à la place de exe.
+func rollbackBinary(exe, oldPath string) error {
+	if runtime.GOOS == "windows" {
+		os.Remove(exe) // le nouveau binaire ne tourne pas, il peut être supprimé
+	}
+	if err := os.Rename(oldPath, exe); err != nil {
 		return err
 	}
+	syncDir(filepath.Dir(exe))
+	return nil
+}
 
-	// 5. Rendre le fichier exécutable (Linux/Mac)
-	os.Chmod(executablePath, 0755)
+// syncDir force l'écriture des renommages d'un dossier (sans effet sous Windows).
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
 
+// selfCheck lance exe --version et vérifie qu'il répond avec la version attendue.
+func selfCheck(exe, version string) error {
+	want, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, exe, "--version").Output()
+	if err != nil {
+		return fmt.Errorf("%s --version : %v", filepath.Base(exe), err)
+	}
+	got, err := ParseVersion(strings.TrimSpace(string(out)))
+	if err != nil {
+		return err
+	}
+	if got.Compare(want) != 0 {
+		return fmt.Errorf("version %s annoncée au lieu de %s", got, want)
+	}
 	return nil
 }